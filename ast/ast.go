@@ -1,8 +1,10 @@
 package ast
 
 import(
+	"github.com/cptaffe/lang/persist"
 	"github.com/cptaffe/lang/token"
 	"strconv"
+	"strings"
 	"fmt"
 	"errors"
 )
@@ -16,6 +18,12 @@ const (
 	ItemString
 	ItemVar
 	ItemKey
+	ItemList // list value
+	ItemSym  // symbol, a quoted name
+	ItemVector // vector, a literal with Sub or a value with Vec
+	ItemMap    // map, a literal with Sub or a value with Map
+	ItemAtom   // keyword atom, :name
+	ItemRecord // record value
 )
 
 // variable n-dimensional tree
@@ -32,6 +40,27 @@ type Node struct {
 	Var    string         // variable name
 	VarTree *Tree // var tree
 	Key    token.ItemType // keywords have an itemtype for identification
+	Mark   int            // syntax mark, set on macro arguments during expansion
+	Vec    *persist.Vector // vector value, of *Tree
+	Map    *persist.Map    // map value, of *Entry keyed by the key's String
+	Atom   *Atom           // keyword atom
+	Rec    *Record         // record value
+	Pos    Pos             // where the node was parsed from
+	Type   string          // type a variable or optional parameter is annotated with, "" if none
+}
+
+// Pos is a position in the source, the zero Pos if it isn't known.
+type Pos struct {
+	Line, Col int
+}
+
+func (pos Pos) String() string {
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Col)
+}
+
+// Entry is a key and its value in a map value.
+type Entry struct {
+	Key, Val *Tree
 }
 
 // Append adds a node to the Sub tree of the tree.
@@ -66,6 +95,13 @@ func CopyTree(t *Tree, tr *Tree) *Tree {
 				Var: t.Val.Var, // string
 				VarTree: t.Val.VarTree, // tree for vars
 				Key: t.Val.Key, // int
+				Mark: t.Val.Mark, // int
+				Vec: t.Val.Vec, // immutable
+				Map: t.Val.Map, // immutable
+				Atom: t.Val.Atom, // interned
+				Rec: t.Val.Rec, // immutable
+				Pos: t.Val.Pos, // struct
+				Type: t.Val.Type, // string
 			},
 		}
 	} else {
@@ -80,9 +116,85 @@ func CopyTree(t *Tree, tr *Tree) *Tree {
 	return tr
 }
 
+// Sequence wraps the body of a lambda tree, everything after its
+// parameter list, in a begin when it is more than one expression. The
+// calls of pre and post it begins with, before its last expression,
+// become the clauses of its contract.
+func Sequence(fn *Tree) {
+	if len(fn.Sub) < 3 {
+		return
+	}
+	body := &Tree{
+		Val: &Node{Typ: ItemKey, Key: token.ItemBegin, Var: "begin"},
+		Sub: append([]*Tree(nil), fn.Sub[1:]...),
+	}
+	fn.Sub = []*Tree{fn.Sub[0], body}
+	for _, t := range body.Sub[:len(body.Sub)-1] {
+		if t.Val.Typ != ItemKey || t.Val.Key != token.ItemLambda {
+			break
+		}
+		key, ok := token.Clause(t.Val.Var)
+		if !ok {
+			break
+		}
+		t.Val.Key = key
+	}
+}
+
+// TypeNames are the types a variable can be annotated with, besides
+// those defrecord and deftype define.
+var TypeNames = map[string]bool{
+	"num": true, "int": true, "str": true, "atom": true, "sym": true,
+	"list": true, "vector": true, "map": true, "fn": true, "any": true,
+}
+
+// Annotate turns the (name : type) forms in the parameter list of a
+// lambda tree, or naming the variable an assign tree assigns, into name
+// annotated with type. An optional parameter is annotated as (name :
+// type default), which becomes the (name default) pair with its name
+// annotated. It returns an error for an annotation that isn't of this
+// form or whose type isType doesn't report as one, leaving the rest of
+// the tree as it is.
+func Annotate(tree *Tree, isType func(name string) bool) error {
+	annotate := func(t *Tree, max int) error {
+		if t.Val.Typ != ItemKey || t.Val.Key != token.ItemLambda || len(t.Sub) == 0 || t.Sub[0].Val.Typ != ItemVar || t.Sub[0].Val.Var != ":" {
+			return nil
+		}
+		if len(t.Sub) < 2 || len(t.Sub) > max || t.Sub[1].Val.Typ != ItemVar {
+			return fmt.Errorf("incorrect annotation %s", t)
+		}
+		if typ := t.Sub[1].Val.Var; !isType(typ) {
+			return fmt.Errorf("unknown type %s annotating %s", typ, t.Val.Var)
+		}
+		if len(t.Sub) == 2 {
+			t.Val = &Node{Typ: ItemVar, Var: t.Val.Var, Type: t.Sub[1].Val.Var, Pos: t.Val.Pos}
+			t.Sub = nil
+		} else {
+			t.Val.Type = t.Sub[1].Val.Var
+			t.Sub = t.Sub[2:]
+		}
+		return nil
+	}
+	switch {
+	case len(tree.Sub) == 0 || tree.Val.Typ != ItemKey:
+	case tree.Val.Key == token.ItemAssign:
+		return annotate(tree.Sub[0], 2)
+	case tree.Val.Key == token.ItemFunction && tree.Sub[0].Val.Typ == ItemKey && tree.Sub[0].Val.Key == token.ItemList:
+		for _, p := range tree.Sub[0].Sub {
+			if err := annotate(p, 3); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // String interfaces
 
 func (tree *Tree) String() string {
+	if tree.Val != nil && (tree.Val.Typ == ItemVector || tree.Val.Typ == ItemMap) {
+		return tree.collection()
+	}
 	var s string
 	if tree.Val != nil {
 		s += tree.Val.String()
@@ -107,10 +219,44 @@ func (tree *Tree) String() string {
 			}
 		}
 		s = fmt.Sprintf("%s{%s}", s, str)
+	} else if tree.Val != nil && tree.Val.Typ == ItemList {
+		s += "{}"
 	}
 	return s
 }
 
+// collection writes a vector as [a, b] and a map as {k v, k v}.
+func (tree *Tree) collection() string {
+	var elems []string
+	switch {
+	case tree.Val.Vec != nil:
+		for _, x := range tree.Val.Vec.Values() {
+			elems = append(elems, x.(*Tree).String())
+		}
+	case tree.Val.Map != nil:
+		tree.Val.Map.Each(func(key string, val interface{}) {
+			e := val.(*Entry)
+			elems = append(elems, e.Key.String()+" "+e.Val.String())
+		})
+	case tree.Val.Typ == ItemMap:
+		for i := 0; i < len(tree.Sub); i += 2 {
+			e := tree.Sub[i].String()
+			if i+1 < len(tree.Sub) {
+				e += " " + tree.Sub[i+1].String()
+			}
+			elems = append(elems, e)
+		}
+	default:
+		for _, t := range tree.Sub {
+			elems = append(elems, t.String())
+		}
+	}
+	if tree.Val.Typ == ItemVector {
+		return "[" + strings.Join(elems, ", ") + "]"
+	}
+	return "{" + strings.Join(elems, ", ") + "}"
+}
+
 func (node *Node) String() string {
 	switch node.Typ {
 	case ItemNum:
@@ -118,6 +264,8 @@ func (node *Node) String() string {
 	case ItemVar:
 		if node.VarTree != nil {
 			return fmt.Sprintf("(%s:%s)", node.Var, node.VarTree)
+		} else if node.Type != "" {
+			return fmt.Sprintf("(%s : %s)", node.Var, node.Type)
 		} else {
 			return fmt.Sprintf("(%s)", node.Var)
 		}
@@ -132,6 +280,18 @@ func (node *Node) String() string {
 		return fmt.Sprintf("%s", token.StringLookup(node.Key))
 	case ItemString:
 		return fmt.Sprintf("\"%s\"", node.Str)
+	case ItemList:
+		return "list"
+	case ItemSym:
+		return node.Var
+	case ItemVector:
+		return "vector"
+	case ItemMap:
+		return "map"
+	case ItemAtom:
+		return node.Atom.String()
+	case ItemRecord:
+		return node.Rec.String()
 	default:
 		return "unk"
 	}
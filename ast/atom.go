@@ -0,0 +1,31 @@
+package ast
+
+import "sync"
+
+// Atom is a keyword atom, :name, which evaluates to itself. Atoms are
+// interned: there is one Atom for each name, so atoms are equal when
+// their pointers are.
+type Atom struct {
+	Name string
+}
+
+var atoms = struct {
+	sync.Mutex
+	m map[string]*Atom
+}{m: make(map[string]*Atom)}
+
+// Intern returns the atom named name.
+func Intern(name string) *Atom {
+	atoms.Lock()
+	defer atoms.Unlock()
+	a, ok := atoms.m[name]
+	if !ok {
+		a = &Atom{Name: name}
+		atoms.m[name] = a
+	}
+	return a
+}
+
+func (a *Atom) String() string {
+	return ":" + a.Name
+}
@@ -0,0 +1,15 @@
+package ast
+
+import "testing"
+
+func TestIntern(t *testing.T) {
+	if a, b := Intern("red"), Intern("red"); a != b {
+		t.Errorf("Intern(red) gave %p and %p, want one atom", a, b)
+	}
+	if a, b := Intern("red"), Intern("green"); a == b {
+		t.Errorf("Intern(red) and Intern(green) gave the same atom")
+	}
+	if s := Intern("red").String(); s != ":red" {
+		t.Errorf("Intern(red) is %s, want :red", s)
+	}
+}
@@ -0,0 +1,32 @@
+package ast
+
+import "strings"
+
+// Record is a value of a record type, holding a value for each of its
+// fields in the order they are declared. Variants of sum types are
+// records of the variant's type with Sum set. Records are immutable.
+type Record struct {
+	Type   *Atom
+	Sum    *Atom // the sum type of a variant, nil for other records
+	Fields []*Atom
+	Vals   []*Tree
+}
+
+// Field returns the value of field f of r and whether r has it.
+func (r *Record) Field(f *Atom) (*Tree, bool) {
+	for i, g := range r.Fields {
+		if g == f {
+			return r.Vals[i], true
+		}
+	}
+	return nil, false
+}
+
+// String writes r as type{field value, ...}, e.g. point{x 1, y 2}.
+func (r *Record) String() string {
+	fields := make([]string, len(r.Fields))
+	for i, f := range r.Fields {
+		fields[i] = f.Name + " " + r.Vals[i].String()
+	}
+	return r.Type.Name + "{" + strings.Join(fields, ", ") + "}"
+}
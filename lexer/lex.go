@@ -28,6 +28,27 @@ type lexer struct {
 	lastPos    token.Pos        // position of most recent item returned by nextItem
 	Items      chan token.Token // channel of scanned items
 	parenDepth int              // nesting depth of ( ) exprs
+	quoting    []bool           // whether each open list holds data, not code
+	closing    []rune           // the delimiter closing each open list
+	prefix     int              // mode of the element after a quote prefix
+}
+
+// modes a quote prefix puts the next element in
+const (
+	modeNone = iota
+	modeData // quoted
+	modeCode // unquoted
+)
+
+// data reports whether the next element is quoted data.
+func (l *lexer) data() bool {
+	switch {
+	case l.prefix != modeNone:
+		return l.prefix == modeData
+	case len(l.quoting) > 0:
+		return l.quoting[len(l.quoting)-1]
+	}
+	return false
 }
 
 // next returns the next rune in the input.
@@ -127,12 +148,18 @@ const (
 	// call deliminators
 	leftList  = '('
 	rightList = ')'
+	// vector deliminators
+	leftVector  = '['
+	rightVector = ']'
+	// map deliminators
+	leftMap  = '{'
+	rightMap = '}'
 	// comment deliminators
 	leftComment  = "/*"
 	rightComment = "*/"
 )
 
-// lexAll scans until it runs into a list
+// lexAll scans until it runs into a list, vector or map
 func lexAll(l *lexer) stateFn {
 	for {
 		r := l.next()
@@ -140,12 +167,18 @@ func lexAll(l *lexer) stateFn {
 			break
 		} else if isSpace(r) || r == '\n' {
 			// consume
-		} else if r == leftList {
+		} else if r == leftList || r == leftVector || r == leftMap {
 			l.backup()
 			if l.start < l.pos {
 				l.emit(token.ItemSpace)
 			}
 			return lexList
+		} else if isPrefix(r) {
+			l.backup()
+			if l.start < l.pos {
+				l.emit(token.ItemSpace)
+			}
+			return lexPrefix
 		} else {
 			// r is not a list
 			return l.errorf("unexpected item: %#U", r)
@@ -156,20 +189,48 @@ func lexAll(l *lexer) stateFn {
 	return nil
 }
 
-// lexList scans deliminators for a list
+// lexList scans deliminators for a list, vector or map
 func lexList(l *lexer) stateFn {
 	r := l.next()
-	if r == leftList {
+	switch r {
+	case leftList, leftVector, leftMap:
+		data := l.data()
+		l.prefix = modeNone
+		l.quoting = append(l.quoting, data)
 		l.parenDepth++
-		l.emit(token.ItemBeginList)
-		//return lexInsideList
+		switch r {
+		case leftList:
+			l.closing = append(l.closing, rightList)
+			l.emit(token.ItemBeginList)
+		case leftVector:
+			l.closing = append(l.closing, rightVector)
+			l.emit(token.ItemBeginVector)
+		case leftMap:
+			l.closing = append(l.closing, rightMap)
+			l.emit(token.ItemBeginMap)
+		}
+		if data || r != leftList {
+			// data lists, vectors and maps have no keyword
+			return lexInsideList
+		}
 		return lexKeyword
-	} else if r == rightList {
+	case rightList, rightVector, rightMap:
 		l.parenDepth--
 		if l.parenDepth < 0 {
 			return l.errorf("unexpected right paren: %#U", r)
+		} else if want := l.closing[len(l.closing)-1]; r != want {
+			return l.errorf("unexpected %#U, expected %#U", r, want)
 		} else {
-			l.emit(token.ItemEndList)
+			l.quoting = l.quoting[:len(l.quoting)-1]
+			l.closing = l.closing[:len(l.closing)-1]
+			switch r {
+			case rightList:
+				l.emit(token.ItemEndList)
+			case rightVector:
+				l.emit(token.ItemEndVector)
+			case rightMap:
+				l.emit(token.ItemEndMap)
+			}
 		}
 		if l.parenDepth == 0 {
 			return lexAll // could be anywhere
@@ -185,37 +246,102 @@ func lexList(l *lexer) stateFn {
 func lexInsideList(l *lexer) stateFn {
 	// Either number, quoted string, or Variable.
 	// Spaces separate arguments; runs of spaces turn into itemSpace.
+	if l.parenDepth == 0 && l.prefix == modeNone {
+		// the element after a top level quote prefix is done
+		return lexAll
+	}
 	r := l.next()
+	data := l.data()
 	switch {
+	case r == token.Eof && l.parenDepth == 0:
+		return l.errorf("nothing to quote")
 	case r == token.Eof:
 		return l.errorf("unclosed list")
 	case isSpace(r):
 		return lexSpace
 	case isEndOfLine(r):
 		return lexEndOfLine
-	case r == leftList || r == rightList:
+	case isDelim(r):
 		l.backup()
 		return lexList
-	case r == '/':
+	case r == '/' && (!data || l.peek() == '/' || l.peek() == '*'):
 		return lexComment
+	case isPrefix(r):
+		l.backup()
+		return lexPrefix
+	}
+	// a single element, the pending prefix only applies to it
+	l.prefix = modeNone
+	switch {
 	case r == '"':
 		return lexQuote
-	case r == '`':
-		return lexRawQuote
-	case r == '\'':
-		return lexChar
-	case r == '-' || r == '+' || ('0' <= r && r <= '9'):
+	case (r == '-' || r == '+') && (!data || unicode.IsDigit(l.peek())), '0' <= r && r <= '9':
 		l.backup()
 		return lexNumber
+	case r == ':' && isAlphaNumeric(l.peek()):
+		return lexAtom
+	case data:
+		l.backup()
+		return lexSymbol
 	case isAlphaNumeric(r):
 		l.backup()
 		return lexVariable
+	case r == '&' && isAlphaNumeric(l.peek()):
+		// &rest in parameter lists
+		return lexVariable
+	case r == '.' && (isSpace(l.peek()) || isEndOfLine(l.peek())):
+		l.emit(token.ItemVariable)
+		return lexInsideList
+	case r == ':' && (isSpace(l.peek()) || isEndOfLine(l.peek())):
+		// (name : type) annotations
+		l.emit(token.ItemVariable)
+		return lexInsideList
 	default:
 		return l.errorf("unexpected item: %#U", r)
 	}
 	return lexInsideList
 }
 
+// lexPrefix scans a quote prefix: ' or ` quote the next element,
+// , and ,@ unquote it.
+func lexPrefix(l *lexer) stateFn {
+	switch l.next() {
+	case '\'':
+		l.prefix = modeData
+		l.emit(token.ItemQuote)
+	case '`':
+		l.prefix = modeData
+		l.emit(token.ItemQuasiquote)
+	case ',':
+		l.prefix = modeCode
+		if l.accept("@") {
+			l.emit(token.ItemUnquoteSplicing)
+		} else {
+			l.emit(token.ItemUnquote)
+		}
+	}
+	// the element it applies to, at the top level too
+	return lexInsideList
+}
+
+// lexSymbol scans a quoted word, which may be spelled like a keyword.
+func lexSymbol(l *lexer) stateFn {
+	for {
+		r := l.next()
+		if r == token.Eof || isSpace(r) || isEndOfLine(r) || isDelim(r) {
+			l.backup()
+			break
+		}
+	}
+	switch word := l.input[l.start:l.pos]; {
+	case word == "true", word == "false":
+		l.emit(token.ItemBool)
+	default:
+		l.emit(token.ItemVariable)
+	}
+	return lexInsideList
+}
+
 // lexSpace scans a run of space characters.
 // One space has already been seen.
 func lexSpace(l *lexer) stateFn {
@@ -226,13 +352,17 @@ func lexSpace(l *lexer) stateFn {
 	return lexInsideList
 }
 
-// lexVariable scans an alphanumeric.
+// lexVariable scans an alphanumeric, which may go on with a - before
+// a letter or end with ?, like point-x or point?.
 func lexVariable(l *lexer) stateFn {
 Loop:
 	for {
 		switch r := l.next(); {
-		case isAlphaNumeric(r):
+		case isAlphaNumeric(r), r == '-' && unicode.IsLetter(l.peek()):
 			// absorb.
+		case r == '?':
+			l.emit(token.ItemVariable)
+			break Loop
 		default:
 			l.backup()
 			word := l.input[l.start:l.pos]
@@ -248,13 +378,22 @@ Loop:
 	return lexInsideList
 }
 
+// lexAtom scans a keyword atom, the : has already been seen.
+func lexAtom(l *lexer) stateFn {
+	for isAlphaNumeric(l.peek()) {
+		l.next()
+	}
+	l.emit(token.ItemAtom)
+	return lexInsideList
+}
+
 // lexKeyword scans a keyword
 // if no keyword is found, it is a list.
 func lexKeyword(l *lexer) stateFn {
 	for {
 		switch r := l.next(); {
 		//case isAlphaNumeric(r):
-		case !isSpace(r) && r != rightList && r != leftList && !isEndOfLine(r):
+		case !isSpace(r) && !isDelim(r) && !isEndOfLine(r):
 			// absorb.
 		default:
 			l.backup()
@@ -262,10 +401,18 @@ func lexKeyword(l *lexer) stateFn {
 			switch {
 			case token.IsKeyword(word):
 				l.emit(token.Lookup(word))
+				if t := token.Lookup(word); t == token.ItemQuote || t == token.ItemQuasiquote {
+					// the rest of the list is data
+					l.quoting[len(l.quoting)-1] = true
+				}
 				return lexInsideList
-			case isAlphaNumericWord(word[:len(word)-1]):
+			case isName(word):
 				l.emit(token.ItemLambda)
 				return lexInsideList
+			case word == "" && r == leftList:
+				// the head is computed by the list that follows
+				l.emit(token.ItemSubAsOp)
+				return lexInsideList
 			default:
 				if len(word) > 0{
 					return l.errorf("unexpected nonkeyword \"%s\"", word)
@@ -278,27 +425,6 @@ func lexKeyword(l *lexer) stateFn {
 	return lexInsideList
 }
 
-// lexChar scans a character constant. The initial quote is already
-// scanned. Syntax checking is done by the parser.
-func lexChar(l *lexer) stateFn {
-Loop:
-	for {
-		switch l.next() {
-		case '\\':
-			if r := l.next(); r != token.Eof && r != '\n' {
-				break
-			}
-			fallthrough
-		case token.Eof, '\n':
-			return l.errorf("unterminated character constant")
-		case '\'':
-			break Loop
-		}
-	}
-	l.emit(token.ItemChar)
-	return lexInsideList
-}
-
 // lexNumber scans a number: decimal, octal, hex, float, or imaginary. This
 // isn't a perfect number scanner - for instance it accepts "." and "0x0.2"
 // and "089" - but when it's wrong the input is invalid and the parser (via
@@ -365,21 +491,6 @@ Loop:
 	return lexInsideList
 }
 
-// lexRawQuote scans a raw quoted string.
-func lexRawQuote(l *lexer) stateFn {
-Loop:
-	for {
-		switch l.next() {
-		case token.Eof, '\n':
-			return l.errorf("unterminated raw quoted string")
-		case '`':
-			break Loop
-		}
-	}
-	l.emit(token.ItemRawString)
-	return lexInsideList
-}
-
 // lexEndOfLine is called when on a newline
 func lexEndOfLine(l *lexer) stateFn {
 	l.emit(token.ItemNewline)
@@ -425,19 +536,26 @@ func isSpace(r rune) bool {
 	return r == ' ' || r == '\t'
 }
 
+// isDelim reports whether r opens or closes a list, vector or map.
+func isDelim(r rune) bool {
+	return strings.ContainsRune("()[]{}", r)
+}
+
+// isPrefix reports whether r starts a quote prefix.
+func isPrefix(r rune) bool {
+	return r == '\'' || r == '`' || r == ','
+}
+
 // isEndOfLine reports whether r is an end-of-line character.
 func isEndOfLine(r rune) bool {
 	return r == '\r' || r == '\n'
 }
 
-func isAlphaNumericWord(w string) bool {
-	for i := 0; i < len(w); i++ {
-		if !isAlphaNumeric(rune(w[i])) {
-			return false
-		}
-		return true
-	}
-	return false
+// isName reports whether w can name a lambda, which it can if it
+// starts with a letter, digit or underscore.
+func isName(w string) bool {
+	r, _ := utf8.DecodeRuneInString(w)
+	return len(w) > 0 && isAlphaNumeric(r)
 }
 
 // isAlphaNumeric reports whether r is an alphabetic, digit, or underscore.
@@ -0,0 +1,52 @@
+package lexer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cptaffe/lang/token"
+)
+
+// items lexes s, returning the types of its items other than spaces
+// and newlines.
+func items(s string) []token.ItemType {
+	var typs []token.ItemType
+	for it := range Lex(s, "test").Items {
+		if it.Typ != token.ItemSpace && it.Typ != token.ItemNewline {
+			typs = append(typs, it.Typ)
+		}
+	}
+	return typs
+}
+
+func TestPrefixes(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []token.ItemType
+	}{
+		// at the top level
+		{"'x", []token.ItemType{token.ItemQuote, token.ItemVariable, token.ItemEOF}},
+		{"`x", []token.ItemType{token.ItemQuasiquote, token.ItemVariable, token.ItemEOF}},
+		{",x", []token.ItemType{token.ItemUnquote, token.ItemVariable, token.ItemEOF}},
+		{",@x", []token.ItemType{token.ItemUnquoteSplicing, token.ItemVariable, token.ItemEOF}},
+		{"'x 'y\n", []token.ItemType{token.ItemQuote, token.ItemVariable, token.ItemQuote, token.ItemVariable, token.ItemEOF}},
+		{"''x", []token.ItemType{token.ItemQuote, token.ItemQuote, token.ItemVariable, token.ItemEOF}},
+		{"'map", []token.ItemType{token.ItemQuote, token.ItemVariable, token.ItemEOF}},
+		{"'1", []token.ItemType{token.ItemQuote, token.ItemNumber, token.ItemEOF}},
+		{"'(a)", []token.ItemType{token.ItemQuote, token.ItemBeginList, token.ItemVariable, token.ItemEndList, token.ItemEOF}},
+		{"'", []token.ItemType{token.ItemQuote, token.ItemError}},
+		// nested
+		{"(f 'x `y ,z ,@w)", []token.ItemType{
+			token.ItemBeginList, token.ItemLambda,
+			token.ItemQuote, token.ItemVariable,
+			token.ItemQuasiquote, token.ItemVariable,
+			token.ItemUnquote, token.ItemVariable,
+			token.ItemUnquoteSplicing, token.ItemVariable,
+			token.ItemEndList, token.ItemEOF}},
+	}
+	for _, test := range tests {
+		if got := items(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q lexed as %v, want %v", test.in, got, test.want)
+		}
+	}
+}
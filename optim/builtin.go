@@ -0,0 +1,105 @@
+package optim
+
+import (
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+)
+
+// builtin is an operation on argument values.
+type builtin func(scope *Scope, args []*ast.Tree) *ast.Tree
+
+// builtins are applied once all of their arguments have values.
+var builtins map[token.ItemType]builtin
+
+// builtins are set here as some of them evaluate code, which looks them up
+func init() {
+	builtins = map[token.ItemType]builtin{
+		token.ItemList:         evalMakeList,
+		token.ItemCons:         evalCons,
+		token.ItemCar:          evalCar,
+		token.ItemCdr:          evalCdr,
+		token.ItemLength:       evalLength,
+		token.ItemAppend:       evalAppend,
+		token.ItemNth:          evalNth,
+		token.ItemNull:         evalNull,
+		token.ItemIsList:       evalIsList,
+		token.ItemGensym:       evalGensym,
+		token.ItemPartial:      evalPartial,
+		token.ItemCurry:        evalCurry,
+		token.ItemMap:          evalMap,
+		token.ItemFilter:       evalFilter,
+		token.ItemReduce:       evalReduce,
+		token.ItemApply:        evalApply,
+		token.ItemCompose:      evalCompose,
+		token.ItemSortBy:       evalSortBy,
+		token.ItemConcat:       evalConcat,
+		token.ItemStrlen:       evalStrlen,
+		token.ItemSubstr:       evalSubstr,
+		token.ItemSplit:        evalSplit,
+		token.ItemJoin:         evalJoin,
+		token.ItemUpper:        evalUpper,
+		token.ItemLower:        evalLower,
+		token.ItemContains:     evalContains,
+		token.ItemFormat:       evalFormat,
+		token.ItemToString:     evalToString,
+		token.ItemToNumber:     evalToNumber,
+		token.ItemGet:          evalGet,
+		token.ItemAssoc:        evalAssoc,
+		token.ItemDissoc:       evalDissoc,
+		token.ItemKeys:         evalKeys,
+		token.ItemVals:         evalVals,
+		token.ItemRecord:       evalRecord,
+		token.ItemIsRecord:     evalIsRecord,
+		token.ItemField:        evalField,
+		token.ItemVariant:      evalVariant,
+		token.ItemIsVariant:    evalIsVariant,
+		token.ItemThrow:        evalThrow,
+		token.ItemNewError:     evalNewError,
+		token.ItemIsError:      evalIsError,
+		token.ItemErrorMessage: evalErrorMessage,
+	}
+}
+
+// evalBuiltin evaluates the arguments of tree and applies fn to them,
+// leaving tree as it is while any of them is unknown.
+func (scope *Scope) evalBuiltin(tree *ast.Tree, fn builtin) *ast.Tree {
+	known := true
+	for i := 0; i < len(tree.Sub); i++ {
+		if t := scope.force(tree.Sub[i]); t != nil {
+			tree.Sub[i] = t
+		}
+		known = known && isValue(tree.Sub[i])
+	}
+	if !known {
+		return nil
+	}
+	return fn(scope, tree.Sub)
+}
+
+// isValue reports whether tree is a value rather than code. Lambdas
+// that aren't being called are function values.
+func isValue(tree *ast.Tree) bool {
+	switch tree.Val.Typ {
+	case ast.ItemNum, ast.ItemString, ast.ItemList, ast.ItemSym, ast.ItemAtom, ast.ItemRecord:
+		return true
+	}
+	return isFunction(tree) || isVector(tree) || isMap(tree)
+}
+
+// num returns a number.
+func num(n float64) *ast.Tree {
+	return &ast.Tree{
+		Val: &ast.Node{
+			Typ: ast.ItemNum,
+			Num: n,
+		},
+	}
+}
+
+// truth returns 1 for true and 0 for false.
+func truth(b bool) *ast.Tree {
+	if b {
+		return num(1)
+	}
+	return num(0)
+}
@@ -0,0 +1,209 @@
+package optim
+
+import (
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/persist"
+)
+
+// Vectors and maps are persistent: assoc and dissoc return new values
+// sharing structure with the old ones, which are left as they are. A
+// literal, [a b] or {k v}, has its elements in Sub until they are all
+// values, when it becomes a value with them in Vec or Map. Map keys are
+// compared, and kept in order, by how they are written.
+
+// isVector reports whether tree is a vector value.
+func isVector(tree *ast.Tree) bool {
+	return tree.Val.Typ == ast.ItemVector && tree.Val.Vec != nil
+}
+
+// isMap reports whether tree is a map value.
+func isMap(tree *ast.Tree) bool {
+	return tree.Val.Typ == ast.ItemMap && tree.Val.Map != nil
+}
+
+// isLiteral reports whether tree is a vector or map literal.
+func isLiteral(tree *ast.Tree) bool {
+	return (tree.Val.Typ == ast.ItemVector || tree.Val.Typ == ast.ItemMap) && !isVector(tree) && !isMap(tree)
+}
+
+// newVector returns a vector value holding vec.
+func newVector(vec *persist.Vector) *ast.Tree {
+	return &ast.Tree{Val: &ast.Node{Typ: ast.ItemVector, Vec: vec}}
+}
+
+// newMap returns a map value holding m.
+func newMap(m *persist.Map) *ast.Tree {
+	return &ast.Tree{Val: &ast.Node{Typ: ast.ItemMap, Map: m}}
+}
+
+// evalLiteral evaluates the elements of a vector or map literal, giving
+// its value once they are all known.
+func (scope *Scope) evalLiteral(tree *ast.Tree) *ast.Tree {
+	if !isLiteral(tree) {
+		return tree
+	}
+	known := true
+	for i := 0; i < len(tree.Sub); i++ {
+		if t := scope.force(tree.Sub[i]); t != nil {
+			tree.Sub[i] = t
+		}
+		known = known && isValue(tree.Sub[i])
+	}
+	if !known {
+		return nil
+	}
+	return scope.collect(tree)
+}
+
+// collect returns the value of a literal whose elements are values.
+func (scope *Scope) collect(tree *ast.Tree) *ast.Tree {
+	if tree.Val.Typ == ast.ItemVector {
+		vec := new(persist.Vector)
+		for _, t := range tree.Sub {
+			vec = vec.Append(t)
+		}
+		return newVector(vec)
+	}
+	if len(tree.Sub)%2 != 0 {
+		scope.errorf("map literal %s has a key without a value", tree)
+		return nil
+	}
+	m := new(persist.Map)
+	for i := 0; i < len(tree.Sub); i += 2 {
+		m = m.Set(tree.Sub[i].String(), &ast.Entry{Key: tree.Sub[i], Val: tree.Sub[i+1]})
+	}
+	return newMap(m)
+}
+
+// data returns quoted data with the vector and map literals in it made
+// values.
+func (scope *Scope) data(tree *ast.Tree) *ast.Tree {
+	if tree == nil || len(tree.Sub) == 0 && !isLiteral(tree) {
+		return tree
+	}
+	t := &ast.Tree{Val: tree.Val}
+	for _, sub := range tree.Sub {
+		d := scope.data(sub)
+		if d == nil {
+			return nil
+		}
+		t.Sub = append(t.Sub, d)
+	}
+	if isLiteral(t) {
+		return scope.collect(t)
+	}
+	return t
+}
+
+// elems returns the elements of a vector value.
+func elems(tree *ast.Tree) []*ast.Tree {
+	var ts []*ast.Tree
+	for _, x := range tree.Val.Vec.Values() {
+		ts = append(ts, x.(*ast.Tree))
+	}
+	return ts
+}
+
+// entries returns the entries of a map value in key order.
+func entries(tree *ast.Tree) []*ast.Entry {
+	var es []*ast.Entry
+	tree.Val.Map.Each(func(key string, val interface{}) {
+		es = append(es, val.(*ast.Entry))
+	})
+	return es
+}
+
+// (get c k default) is element k of the vector or map c, or default if
+// c has no element k.
+func evalGet(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) < 2 || len(args) > 3 || !isVector(args[0]) && !isMap(args[0]) {
+		scope.errorf("get takes a vector or map, a key and an optional default")
+		return nil
+	}
+	if isVector(args[0]) {
+		i, ok := scope.index("get", args[1])
+		if !ok {
+			return nil
+		}
+		if 0 <= i && i < args[0].Val.Vec.Len() {
+			return args[0].Val.Vec.Get(i).(*ast.Tree)
+		}
+	} else if e, ok := args[0].Val.Map.Get(args[1].String()); ok {
+		return e.(*ast.Entry).Val
+	}
+	if len(args) == 3 {
+		return args[2]
+	}
+	scope.errorf("get: %s has no element %s", args[0], args[1])
+	return nil
+}
+
+// (assoc c k v...) is the vector or map c with each element k set to
+// the v after it. A vector grows by setting the element after its end.
+func evalAssoc(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) < 3 || len(args)%2 == 0 || !isVector(args[0]) && !isMap(args[0]) {
+		scope.errorf("assoc takes a vector or map and keys each followed by a value")
+		return nil
+	}
+	if isMap(args[0]) {
+		m := args[0].Val.Map
+		for i := 1; i < len(args); i += 2 {
+			m = m.Set(args[i].String(), &ast.Entry{Key: args[i], Val: args[i+1]})
+		}
+		return newMap(m)
+	}
+	vec := args[0].Val.Vec
+	for i := 1; i < len(args); i += 2 {
+		n, ok := scope.index("assoc", args[i])
+		if !ok {
+			return nil
+		}
+		switch {
+		case 0 <= n && n < vec.Len():
+			vec = vec.Set(n, args[i+1])
+		case n == vec.Len():
+			vec = vec.Append(args[i+1])
+		default:
+			scope.errorf("assoc index %d out of range for a vector of %d elements", n, vec.Len())
+			return nil
+		}
+	}
+	return newVector(vec)
+}
+
+// (dissoc m k...) is the map m without the keys k.
+func evalDissoc(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) == 0 || !isMap(args[0]) {
+		scope.errorf("dissoc takes a map and keys")
+		return nil
+	}
+	m := args[0].Val.Map
+	for _, k := range args[1:] {
+		m = m.Delete(k.String())
+	}
+	return newMap(m)
+}
+
+func evalKeys(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 1 || !isMap(args[0]) {
+		scope.errorf("keys takes a map")
+		return nil
+	}
+	var ks []*ast.Tree
+	for _, e := range entries(args[0]) {
+		ks = append(ks, e.Key)
+	}
+	return newList(ks)
+}
+
+func evalVals(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 1 || !isMap(args[0]) {
+		scope.errorf("vals takes a map")
+		return nil
+	}
+	var vs []*ast.Tree
+	for _, e := range entries(args[0]) {
+		vs = append(vs, e.Val)
+	}
+	return newList(vs)
+}
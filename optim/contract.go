@@ -0,0 +1,179 @@
+package optim
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+	"github.com/cptaffe/lang/variable"
+)
+
+// Contracts are checked as a program is evaluated: (assert cond) is an
+// error unless cond is 1, and a lambda whose body begins with (pre
+// cond...) and (post cond...) clauses checks its pre conditions once its
+// parameters are bound and its post conditions, in which result is the
+// value of its body, once that is known. A failure is raised like the
+// errors of builtins, at the form being evaluated, and names the values
+// the condition was checked with. Options.NoContracts turns them off.
+
+// evalAssert evaluates (assert cond) and (assert cond message), giving
+// 1 if cond is 1.
+func (scope *Scope) evalAssert(tree *ast.Tree) *ast.Tree {
+	if len(tree.Sub) < 1 || len(tree.Sub) > 2 {
+		scope.errorf("assert takes a condition and an optional message")
+		return nil
+	}
+	if scope.state.NoContracts {
+		return num(1)
+	}
+	cond := tree.Sub[0]
+	c := scope.value(ast.CopyTree(cond, new(ast.Tree)))
+	if !isValue(c) {
+		if scope.known(cond) {
+			scope.errorf("assertion %s cannot be decided, it is %s", cond, c)
+		}
+		return nil
+	}
+	if c.Val.Typ == ast.ItemNum && c.Val.Num == 1 {
+		return num(1)
+	}
+	msg := fmt.Sprintf("assertion %s failed", cond)
+	if len(tree.Sub) == 2 {
+		m := scope.value(tree.Sub[1])
+		if !isString(m) {
+			return nil
+		}
+		msg = m.Val.Str
+	}
+	if b := scope.bindings(vars(cond)); b != "" {
+		msg += " for " + b
+	}
+	scope.errorf("%s", msg)
+	return nil
+}
+
+// contracts splits the body of a lambda into the conditions of its pre
+// and post clauses and the rest of it.
+func contracts(body *ast.Tree) (pre, post []*ast.Tree, rest *ast.Tree) {
+	if !isKey(body, token.ItemBegin) {
+		return nil, nil, body
+	}
+	i := 0
+	for ; i < len(body.Sub)-1; i++ {
+		if c := body.Sub[i]; isKey(c, token.ItemPre) {
+			pre = append(pre, c.Sub...)
+		} else if isKey(c, token.ItemPost) {
+			post = append(post, c.Sub...)
+		} else {
+			break
+		}
+	}
+	if i == 0 {
+		return nil, nil, body
+	}
+	return pre, post, sequence(body.Sub[i:])
+}
+
+// holds evaluates the conditions conds of the contract of the lambda
+// name, reporting whether they are known and all hold. A condition that
+// doesn't, or that isn't a value though every variable in it is, is an
+// error naming it and the values of names.
+func (scope *Scope) holds(name, kind string, conds, names []*ast.Tree) bool {
+	for _, cond := range conds {
+		c := scope.value(ast.CopyTree(cond, new(ast.Tree)))
+		if !isValue(c) {
+			if scope.known(cond) {
+				msg := fmt.Sprintf("%s: %s %s cannot be decided, it is %s", name, kind, cond, c)
+				if b := scope.bindings(names); b != "" {
+					msg += " for " + b
+				}
+				scope.errorf("%s", msg)
+			}
+			return false
+		}
+		if c.Val.Typ != ast.ItemNum || c.Val.Num != 1 {
+			msg := fmt.Sprintf("%s: %s %s failed", name, kind, cond)
+			if b := scope.bindings(names); b != "" {
+				msg += " for " + b
+			}
+			scope.errorf("%s", msg)
+			return false
+		}
+	}
+	return true
+}
+
+// known reports whether every variable tree references is bound to a
+// value or a function, so that evaluating it gives all it ever will.
+func (scope *Scope) known(tree *ast.Tree) bool {
+	for _, v := range vars(tree) {
+		if t := scope.value(ref(v.Val.Var)); !isValue(t) && !isFunction(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// bindings describes the values of the variables named by names, once
+// each, leaving out those without one.
+func (scope *Scope) bindings(names []*ast.Tree) string {
+	var b []string
+	seen := make(map[string]bool)
+	for _, n := range names {
+		name := n.Val.Var
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if v := scope.value(ref(name)); isValue(v) {
+			b = append(b, fmt.Sprintf("%s = %s", name, v))
+		}
+	}
+	return strings.Join(b, ", ")
+}
+
+// vars returns the variables referenced in tree.
+func vars(tree *ast.Tree) []*ast.Tree {
+	var vs []*ast.Tree
+	walk(tree, func(t *ast.Tree) bool {
+		if t.Val.Typ == ast.ItemVar {
+			vs = append(vs, t)
+		}
+		return true
+	})
+	return vs
+}
+
+// checked evaluates the body of the lambda name in sc, where its
+// parameters are bound, checking its contract. It returns nil, leaving
+// the call as it is, if the contract cannot be checked yet or fails.
+func (sc *Scope) checked(name string, tree *ast.Tree) *ast.Tree {
+	pre, post, body := contracts(tree.Sub[1])
+	if sc.state.NoContracts || pre == nil && post == nil {
+		if t := sc.eval(body); t != nil {
+			return t
+		}
+		return body
+	}
+	names := paramNames(tree.Sub[0])
+	if !sc.holds(name, "precondition", pre, names) {
+		return nil
+	}
+	result := sc.value(body)
+	if post == nil {
+		return result
+	}
+	if !isValue(result) && !isFunction(result) {
+		return nil
+	}
+	rc := sc.childScope()
+	rc.Add(&variable.Var{
+		Var:  "result",
+		Tree: result,
+	})
+	if !rc.holds(name, "postcondition", post, append(names, ref("result"))) {
+		return nil
+	}
+	return result
+}
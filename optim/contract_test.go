@@ -0,0 +1,43 @@
+package optim
+
+import (
+	"testing"
+
+	"github.com/cptaffe/lang/parser"
+)
+
+func TestContracts(t *testing.T) {
+	const f = "(: f (lambda (list n) (pre (> n 0) (>= n 1) (<= n 9)) (post (< result 10)) n)) "
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{f + "(f 3)", "3", false},
+		{f + "(f 0)", "", true},
+		{f + "(f 10)", "", true},
+		// the argument isn't known yet
+		{f + "(f y)", "unk{(y)}", false},
+		{"(: g (lambda (list n) (+ n y))) (: f (lambda (list n) (pre (g n)) n)) (f 3)", "", true},
+		{"(: g (lambda (list n) (+ n y))) (assert (g 1))", "", true},
+		{"(assert (> 2 1))", "1", false},
+		{"(assert (< y 1))", "assert{<{(y), 1}}", false},
+		// pre and post are only keywords at the start of a lambda body
+		{"(: pre (lambda (list x) (+ x 1))) (pre 2)", "3", false},
+		{"(: pre (lambda (list x) (+ x 1))) (: f (lambda (list x) (pre x))) (f 2)", "3", false},
+		{"(: f (lambda (list post) (+ post 1))) (f 2)", "3", false},
+	}
+	for _, test := range tests {
+		tree, err := Run(parser.Parse(test.in, "test"), Options{})
+		if test.err {
+			if err == nil {
+				t.Errorf("%s returned no error", test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.in, err)
+		} else if got := tree.Sub[len(tree.Sub)-1].String(); got != test.want {
+			t.Errorf("%s = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package optim
+
+import (
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+)
+
+// isFunction reports whether tree is a lambda value.
+func isFunction(tree *ast.Tree) bool {
+	return isKey(tree, token.ItemFunction) && len(tree.Sub) == 2
+}
+
+// evalPartial returns (partial f args...): f with its first parameters
+// fixed to args.
+func evalPartial(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) == 0 || !isFunction(args[0]) {
+		scope.errorf("partial takes a function and its first arguments")
+		return nil
+	}
+	return scope.partial("partial", args[0], args[1:])
+}
+
+// evalCurry returns (curry f): a function taking the first argument of
+// f and returning f curried with the rest, until f has all of its
+// required arguments.
+func evalCurry(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 1 || !isFunction(args[0]) {
+		scope.errorf("curry takes a function")
+		return nil
+	}
+	fn := args[0]
+	sig := scope.params(fn.Sub[0])
+	if sig == nil {
+		return nil
+	}
+	if len(sig.required) <= 1 {
+		return fn
+	}
+	x := &ast.Tree{Val: &ast.Node{Typ: ast.ItemVar, Var: fresh("x")}}
+	return call(token.ItemFunction,
+		call(token.ItemList, x),
+		call(token.ItemCurry, call(token.ItemPartial, ast.CopyTree(fn, new(ast.Tree)), ast.CopyTree(x, new(ast.Tree)))))
+}
+
+// partial returns a lambda taking the parameters of fn after its first
+// len(args), which calls fn with args before them. name is what errors
+// call it.
+func (scope *Scope) partial(name string, fn *ast.Tree, args []*ast.Tree) *ast.Tree {
+	sig := scope.params(fn.Sub[0])
+	if sig == nil {
+		return nil
+	}
+	if len(args) > len(sig.required) {
+		scope.errorf("%s: too many arguments, the function takes %s", name, sig.arity())
+		return nil
+	}
+	// the new lambda takes the rest of the parameters and passes them
+	// on, patterns are destructured by fn
+	var outer, inner, pass []*ast.Tree
+	for _, a := range args {
+		pass = append(pass, ast.CopyTree(a, new(ast.Tree)))
+	}
+	for i, p := range sig.required {
+		inner = append(inner, ast.CopyTree(p, new(ast.Tree)))
+		if i < len(args) {
+			continue
+		}
+		v := p
+		if p.Val.Typ != ast.ItemVar {
+			v = &ast.Tree{Val: &ast.Node{Typ: ast.ItemVar, Var: fresh("arg")}}
+		}
+		outer = append(outer, ast.CopyTree(v, new(ast.Tree)))
+		pass = append(pass, ast.CopyTree(v, new(ast.Tree)))
+	}
+	for _, p := range sig.optional {
+		// defaults are taken by the new lambda, fn always gets a value
+		v := &ast.Tree{Val: &ast.Node{Typ: ast.ItemVar, Var: p.Val.Var}}
+		outer = append(outer, ast.CopyTree(p, new(ast.Tree)))
+		inner = append(inner, v)
+		pass = append(pass, ast.CopyTree(v, new(ast.Tree)))
+	}
+	if sig.rest != "" {
+		v := &ast.Tree{Val: &ast.Node{Typ: ast.ItemVar, Var: sig.rest}}
+		outer = append(outer, &ast.Tree{Val: &ast.Node{Typ: ast.ItemVar, Var: "&rest"}}, v)
+		inner = append(inner, ast.CopyTree(v, new(ast.Tree)))
+		pass = append(pass, ast.CopyTree(v, new(ast.Tree)))
+	}
+	// ((lambda (list inner...) body) pass...)
+	body := call(token.ItemSubAsOp, append([]*ast.Tree{call(token.ItemFunction,
+		call(token.ItemList, inner...),
+		ast.CopyTree(fn.Sub[1], new(ast.Tree)))}, pass...)...)
+	return call(token.ItemFunction, call(token.ItemList, outer...), body)
+}
+
+// call returns the code calling the keyword key with args.
+func call(key token.ItemType, args ...*ast.Tree) *ast.Tree {
+	return &ast.Tree{
+		Val: &ast.Node{Typ: ast.ItemKey, Key: key, Var: token.StringLookup(key)},
+		Sub: args,
+	}
+}
+
+// curried returns the lambda fn, called with too few args, as a
+// function taking the rest, once all of args are known.
+func (scope *Scope) curried(name string, fn *ast.Tree, args []*ast.Tree) *ast.Tree {
+	vals := make([]*ast.Tree, len(args))
+	for i := 0; i < len(args); i++ {
+		if vals[i] = scope.value(args[i]); !isValue(vals[i]) {
+			return nil
+		}
+	}
+	return scope.partial(name, fn, vals)
+}
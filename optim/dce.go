@@ -0,0 +1,294 @@
+package optim
+
+import (
+	"fmt"
+
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+)
+
+// Removal describes a piece of code removed by DCE.
+type Removal struct {
+	Kind string    // "assign", "branch" or "param"
+	Name string    // variable, lambda or parameter the code belonged to
+	Tree *ast.Tree // removed code
+}
+
+func (r Removal) String() string {
+	return fmt.Sprintf("%s %s: %s", r.Kind, r.Name, r.Tree)
+}
+
+// keys whose evaluation changes a scope, doesn't return, or gives a
+// new value each time
+var effects = map[token.ItemType]bool{
+	token.ItemAssign: true,
+	token.ItemThrow:  true,
+	token.ItemAssert: true,
+	token.ItemPre:    true,
+	token.ItemPost:   true,
+	token.ItemGensym: true,
+}
+
+// DCE removes dead code from a program tree: cmp branches that a
+// constant condition can never take, top level assigns whose variable
+// is never referenced, and lambda parameters that are never referenced,
+// together with the matching argument at every call site if none of
+// those could have an effect (see inert). Because
+// scoping is dynamic, a name counts as referenced if it appears anywhere
+// in the program. The tree is rewritten in place and every removal is
+// reported.
+func DCE(tree *ast.Tree) (*ast.Tree, []Removal) {
+	var rm []Removal
+	for {
+		n := len(rm)
+		tree = pruneBranches(tree, &rm)
+		pruneAssigns(tree, &rm)
+		pruneParams(tree, &rm)
+		if len(rm) == n {
+			return tree, rm
+		}
+	}
+}
+
+// pruneBranches replaces cmp expressions with constant conditions by
+// the branch they take, the same way evalCmp would.
+func pruneBranches(tree *ast.Tree, rm *[]Removal) *ast.Tree {
+	for i := 0; i < len(tree.Sub); i++ {
+		tree.Sub[i] = pruneBranches(tree.Sub[i], rm)
+	}
+	if !isKey(tree, token.ItemCmp) || len(tree.Sub) != 3 || tree.Sub[0].Val.Typ != ast.ItemNum {
+		return tree
+	}
+	taken, dead := tree.Sub[1], tree.Sub[2]
+	if tree.Sub[0].Val.Num != 1 {
+		taken, dead = dead, taken
+	}
+	*rm = append(*rm, Removal{Kind: "branch", Name: "cmp", Tree: dead})
+	return taken
+}
+
+// pruneAssigns removes top level assigns that nothing else references.
+// Assigned values are not evaluated, so removing them has no effect.
+func pruneAssigns(tree *ast.Tree, rm *[]Removal) {
+	if tree.Val != nil {
+		return
+	}
+	refs := make(map[string]int)
+	countRefs(tree, refs)
+	sub := tree.Sub[:0]
+	for i := 0; i < len(tree.Sub); i++ {
+		t := tree.Sub[i]
+		if isKey(t, token.ItemAssign) && len(t.Sub) == 2 && t.Sub[0].Val.Typ == ast.ItemVar {
+			own := make(map[string]int)
+			countRefs(t, own)
+			name := t.Sub[0].Val.Var
+			if refs[name] == own[name] {
+				*rm = append(*rm, Removal{Kind: "assign", Name: name, Tree: t})
+				// uncount so that anything only it used also dies
+				for k, v := range own {
+					refs[k] -= v
+				}
+				continue
+			}
+		}
+		sub = append(sub, t)
+	}
+	tree.Sub = sub
+}
+
+// pruneParams removes parameters nothing references from lambdas
+// assigned once at the top level, only taking required parameters and
+// only ever called directly.
+func pruneParams(tree *ast.Tree, rm *[]Removal) {
+	if tree.Val != nil {
+		return
+	}
+	refs := make(map[string]int)
+	countRefs(tree, refs)
+	assigns := countAssigns(tree)
+	for i := 0; i < len(tree.Sub); i++ {
+		t := tree.Sub[i]
+		if !isKey(t, token.ItemAssign) || len(t.Sub) != 2 || !isKey(t.Sub[1], token.ItemFunction) {
+			continue
+		}
+		name, fn := t.Sub[0].Val.Var, t.Sub[1]
+		if assigns[name] != 1 || len(fn.Sub) != 2 || refs["self"] > 0 || !simple(fn.Sub[0]) {
+			continue
+		}
+		params := fn.Sub[0].Sub
+		calls, ok := callSites(tree, name, len(params))
+		if !ok {
+			continue
+		}
+		for j := len(params) - 1; j >= 0; j-- {
+			p := params[j].Val.Var
+			if refs[p] > 0 || !pureArgs(calls, j) {
+				continue
+			}
+			*rm = append(*rm, Removal{Kind: "param", Name: name + " " + p, Tree: params[j]})
+			params = append(params[:j], params[j+1:]...)
+			for _, c := range calls {
+				c.Sub = append(c.Sub[:j], c.Sub[j+1:]...)
+			}
+		}
+		fn.Sub[0].Sub = params
+	}
+}
+
+// callSites returns every call of the lambda name, and whether the
+// lambda is only used in calls passing exactly n arguments.
+func callSites(tree *ast.Tree, name string, n int) ([]*ast.Tree, bool) {
+	var calls []*ast.Tree
+	ok := true
+	var visit func(t *ast.Tree) bool
+	visit = func(t *ast.Tree) bool {
+		switch {
+		case isKey(t, token.ItemLambda) && t.Val.Var == name:
+			calls = append(calls, t)
+			ok = ok && len(t.Sub) == n
+		case t.Val != nil && t.Val.Typ == ast.ItemVar && t.Val.Var == name:
+			// escapes as a value or is rebound as a parameter
+			ok = false
+		case isKey(t, token.ItemAssign) && len(t.Sub) == 2:
+			walk(t.Sub[1], visit)
+			return false
+		case isLet(t) || isKey(t, token.ItemMatch):
+			for _, b := range binders(t) {
+				ok = ok && b.Val.Var != name
+			}
+			for i := 1; isKey(t, token.ItemMatch) && i < len(t.Sub); i += 2 {
+				// a pattern like a call takes all of the fields
+				walk(t.Sub[i], func(p *ast.Tree) bool {
+					ok = ok && !(isKey(p, token.ItemLambda) && p.Val.Var == name)
+					return true
+				})
+			}
+		}
+		return true
+	}
+	walk(tree, visit)
+	return calls, ok
+}
+
+// pureArgs reports whether the j'th argument of every call is inert.
+func pureArgs(calls []*ast.Tree, j int) bool {
+	for _, c := range calls {
+		if !inert(c.Sub[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sideEffects reports whether evaluating tree could change a scope,
+// throw or give a new value each time.
+func sideEffects(tree *ast.Tree) bool {
+	found := false
+	walk(tree, func(t *ast.Tree) bool {
+		if t.Val != nil && t.Val.Typ == ast.ItemKey && effects[t.Val.Key] {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// inert reports whether evaluating tree can't change a scope, throw or
+// be an error, so that it can be dropped: constants, names, quoted data,
+// lambda values and lists of them. Any call could do all three, even of
+// a builtin like car.
+func inert(tree *ast.Tree) bool {
+	switch tree.Val.Typ {
+	case ast.ItemNum, ast.ItemString, ast.ItemVar, ast.ItemSym, ast.ItemAtom,
+		ast.ItemList, ast.ItemRecord:
+		return true
+	case ast.ItemVector:
+		for _, t := range tree.Sub {
+			if !inert(t) {
+				return false
+			}
+		}
+		return true
+	case ast.ItemKey:
+		switch tree.Val.Key {
+		case token.ItemQuote, token.ItemFunction:
+			return true
+		case token.ItemList:
+			for _, t := range tree.Sub {
+				if !inert(t) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// countRefs counts the references to each name in tree, not counting
+// the names being bound by assigns, lambda parameter lists and lets.
+func countRefs(tree *ast.Tree, refs map[string]int) {
+	walk(tree, func(t *ast.Tree) bool {
+		if t.Val == nil {
+			return true
+		}
+		switch {
+		case t.Val.Typ == ast.ItemVar:
+			refs[t.Val.Var]++
+		case isLet(t) && len(t.Sub) > 0:
+			for _, b := range t.Sub[0].Sub {
+				for i := 0; i < len(b.Sub); i++ {
+					countRefs(b.Sub[i], refs)
+				}
+			}
+			for i := 1; i < len(t.Sub); i++ {
+				countRefs(t.Sub[i], refs)
+			}
+			return false
+		case isKey(t, token.ItemLambda):
+			refs[t.Val.Var]++
+		case (isKey(t, token.ItemAssign) || isKey(t, token.ItemFunction)) && len(t.Sub) > 0:
+			if isKey(t, token.ItemFunction) {
+				// parameter defaults
+				for _, p := range t.Sub[0].Sub {
+					if isOptional(p) {
+						countRefs(p.Sub[0], refs)
+					}
+				}
+			}
+			for i := 1; i < len(t.Sub); i++ {
+				countRefs(t.Sub[i], refs)
+			}
+			return false
+		}
+		return true
+	})
+}
+
+// countAssigns counts the assigns to each name in tree.
+func countAssigns(tree *ast.Tree) map[string]int {
+	assigns := make(map[string]int)
+	walk(tree, func(t *ast.Tree) bool {
+		if isKey(t, token.ItemAssign) && len(t.Sub) > 0 {
+			assigns[t.Sub[0].Val.Var]++
+		}
+		return true
+	})
+	return assigns
+}
+
+// walk calls fn on tree and its subtrees in pre-order, skipping the
+// subtrees of any tree fn returns false for.
+func walk(tree *ast.Tree, fn func(*ast.Tree) bool) {
+	if !fn(tree) {
+		return
+	}
+	for i := 0; i < len(tree.Sub); i++ {
+		walk(tree.Sub[i], fn)
+	}
+}
+
+// isKey reports whether tree is headed by the keyword key.
+func isKey(tree *ast.Tree, key token.ItemType) bool {
+	return tree.Val != nil && tree.Val.Typ == ast.ItemKey && tree.Val.Key == key
+}
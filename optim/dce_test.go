@@ -0,0 +1,60 @@
+package optim
+
+import (
+	"testing"
+
+	"github.com/cptaffe/lang/parser"
+)
+
+// optimized runs the passes named by passes over s and then evaluates
+// it, returning the value of its last form.
+func optimized(t *testing.T, s string, passes ...string) string {
+	pm, err := NewPassManager(passes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := Eval(pm.Run(parser.Parse(s, "test")))
+	return tree.Sub[len(tree.Sub)-1].String()
+}
+
+func TestDCEKeepsArguments(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		// a call of a lambda that throws
+		{`(: g (lambda (list) (car (list))))
+		  (: f (lambda (list a b) b))
+		  (try (f (g) 2) (catch e 99))`, "99"},
+		// a call of a builtin that throws
+		{`(: f (lambda (list a b) b))
+		  (try (f (car (list)) 2) (catch e 99))`, "99"},
+		// an assign
+		{`(: f (lambda (list a b) b))
+		  (: x 1)
+		  (f (: x 2) 3)
+		  (+ x 0)`, "2"},
+	}
+	for _, test := range tests {
+		if got := optimized(t, test.in, "dce"); got != test.want {
+			t.Errorf("%s = %s after dce, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestDCERemovesParams(t *testing.T) {
+	tree := parser.Parse(`(: f (lambda (list a b) b)) (f 1 2) (f (quote (x)) (lambda (list) 3))`, "test")
+	tree, rm := DCE(tree)
+	if len(rm) != 1 || rm[0].Kind != "param" || rm[0].Name != "f a" {
+		t.Errorf("removed %v, want param f a", rm)
+	}
+	if got := Eval(tree).Sub[1].String(); got != "2" {
+		t.Errorf("(f 1 2) = %s after dce, want 2", got)
+	}
+}
+
+func TestDCEMacros(t *testing.T) {
+	in := "(: helper (lambda (list x) (* x 2))) (macro m (list x) `(helper ,x)) (m 4)"
+	if got := optimized(t, in, "dce"); got != "8" {
+		t.Errorf("%s = %s after dce, want 8", in, got)
+	}
+}
@@ -0,0 +1,213 @@
+package optim
+
+import (
+	"fmt"
+
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+	"github.com/cptaffe/lang/variable"
+)
+
+// Errors are values: (throw v) throws v, which a try around it catches,
+// and the errors builtins raise are thrown as error values, records of
+// type error with a message and the line and column of the form that
+// raised them. Outside a try builtins' errors are only printed, as they
+// always were, unless the program is evaluated by Run.
+
+// Error is a thrown value that wasn't caught.
+type Error struct {
+	Val *ast.Tree // the value thrown, an error value for raised errors
+	Pos ast.Pos   // of the form that threw it
+}
+
+func (e *Error) Error() string {
+	msg := e.Val.String()
+	if m, ok := message(e.Val); ok {
+		msg = m
+	}
+	if e.Pos.Line == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, msg)
+}
+
+var errorType = ast.Intern("error")
+
+// errorValue returns an error value with msg raised at pos.
+func errorValue(msg string, pos ast.Pos) *ast.Tree {
+	return &ast.Tree{Val: &ast.Node{Typ: ast.ItemRecord, Rec: &ast.Record{
+		Type:   errorType,
+		Fields: []*ast.Atom{ast.Intern("message"), ast.Intern("line"), ast.Intern("col")},
+		Vals:   []*ast.Tree{str(msg), num(float64(pos.Line)), num(float64(pos.Col))},
+	}}}
+}
+
+// isError reports whether tree is an error value.
+func isError(tree *ast.Tree) bool {
+	return isRecord(tree) && tree.Val.Rec.Type == errorType
+}
+
+// message returns the message of an error value.
+func message(tree *ast.Tree) (string, bool) {
+	if !isError(tree) {
+		return "", false
+	}
+	m, ok := tree.Val.Rec.Field(ast.Intern("message"))
+	if !ok || !isString(m) {
+		return "", false
+	}
+	return m.Val.Str, true
+}
+
+// errorf reports an error in evaluation, throwing it as an error value
+// inside a try and printing it, after its position, otherwise.
+func (scope *Scope) errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if scope.state.throwing == 0 {
+		if scope.state.pos.Line > 0 {
+			msg = fmt.Sprintf("%s: %s", scope.state.pos, msg)
+		}
+		errorf("%s", msg)
+		return
+	}
+	panic(&Error{Val: errorValue(msg, scope.state.pos), Pos: scope.state.pos})
+}
+
+// at makes pos the position errors are raised at, returning the
+// function restoring the one before it.
+func (scope *Scope) at(pos ast.Pos) func() {
+	prev := scope.state.pos
+	scope.state.pos = pos
+	return func() { scope.state.pos = prev }
+}
+
+// (throw v) throws v, or an error value with the message v if v is a
+// string.
+func evalThrow(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 1 {
+		scope.errorf("throw takes 1 argument")
+		return nil
+	}
+	v := args[0]
+	if isString(v) {
+		v = errorValue(v.Val.Str, scope.state.pos)
+	}
+	panic(&Error{Val: v, Pos: scope.state.pos})
+}
+
+// (error msg) is an error value with the message msg, raised here.
+func evalNewError(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if !scope.strs("error", args, 1) {
+		return nil
+	}
+	return errorValue(args[0].Val.Str, scope.state.pos)
+}
+
+func evalIsError(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 1 {
+		scope.errorf("error? takes 1 argument")
+		return nil
+	}
+	return truth(isError(args[0]))
+}
+
+func evalErrorMessage(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 1 || !isError(args[0]) {
+		scope.errorf("error-message takes an error")
+		return nil
+	}
+	m, _ := message(args[0])
+	return str(m)
+}
+
+// evalTry evaluates (try body... (catch e handler...) (finally
+// cleanup...)), where both clauses are optional. If the body throws, e
+// is bound to what it threw and the handler gives the value instead.
+// The cleanup is evaluated last for its side effects, whether or not
+// anything was thrown, and whatever the handler throws, or the body if
+// there is no handler, is thrown on. The try is left as it is while its
+// body or handler isn't known, but its cleanup is still evaluated, as
+// the body was as far as it could be.
+func (scope *Scope) evalTry(tree *ast.Tree) *ast.Tree {
+	var body []*ast.Tree
+	var catch, finally *ast.Tree
+	for _, t := range tree.Sub {
+		switch {
+		case isKey(t, token.ItemCatch) && catch == nil && finally == nil:
+			if len(t.Sub) < 2 || t.Sub[0].Val.Typ != ast.ItemVar {
+				scope.errorf("incorrect catch syntax %s", t)
+				return nil
+			}
+			catch = t
+		case isKey(t, token.ItemFinally) && finally == nil && len(t.Sub) > 0:
+			finally = t
+		case catch == nil && finally == nil && !isKey(t, token.ItemCatch) && !isKey(t, token.ItemFinally):
+			body = append(body, t)
+		default:
+			scope.errorf("incorrect try syntax %s", tree)
+			return nil
+		}
+	}
+	if len(body) == 0 {
+		scope.errorf("try: nothing to evaluate")
+		return nil
+	}
+	t, err := scope.attempt(sequence(body))
+	if err != nil && catch != nil {
+		sc := scope.childScope()
+		sc.Add(&variable.Var{Var: catch.Sub[0].Val.Var, Tree: err.Val})
+		t, err = sc.attempt(sequence(catch.Sub[1:]))
+	}
+	if finally != nil {
+		// a copy, the try is kept if its value isn't known
+		scope.value(ast.CopyTree(sequence(finally.Sub), new(ast.Tree)))
+	}
+	if t == nil && err == nil {
+		// not known yet
+		return nil
+	}
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// attempt evaluates a copy of tree, catching what it throws. It gives
+// the value of tree, or nil if it isn't known yet, or the error.
+func (scope *Scope) attempt(tree *ast.Tree) (t *ast.Tree, err *Error) {
+	scope.state.throwing++
+	defer func() {
+		scope.state.throwing--
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			t, err = nil, e
+		}
+	}()
+	if t = scope.value(ast.CopyTree(tree, new(ast.Tree))); !isValue(t) {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// sequence returns the code evaluating trees in order.
+func sequence(trees []*ast.Tree) *ast.Tree {
+	if len(trees) == 1 {
+		return trees[0]
+	}
+	return call(token.ItemBegin, trees...)
+}
+
+// uncaught recovers an uncaught *Error, storing it in err, and lets any
+// other panic go on.
+func uncaught(err *error) {
+	if r := recover(); r != nil {
+		e, ok := r.(*Error)
+		if !ok {
+			panic(r)
+		}
+		*err = e
+	}
+}
@@ -0,0 +1,52 @@
+package optim
+
+import (
+	"testing"
+
+	"github.com/cptaffe/lang/parser"
+)
+
+func TestTryFinally(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"(: x 0) (try 1 (finally (: x 5))) (+ x 0)", "5"},
+		{"(: x 0) (try (throw 1) (catch e 2) (finally (: x 5))) (+ x 0)", "5"},
+		// the body isn't known
+		{"(: x 0) (try (+ y 1) (finally (: x 5))) (+ x 0)", "5"},
+		{"(try (+ y 1) (finally (: x 5)))", "try{+{(y), 1}, finally{:{(x), 5}}}"},
+		// the handler isn't known
+		{"(: x 0) (try (car (list)) (catch e (+ y 1)) (finally (: x 5))) (+ x 0)", "5"},
+	}
+	for _, test := range tests {
+		if got := last(test.in); got != test.want {
+			t.Errorf("%s = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+// TestRunErrors checks that Run returns the errors raised outside
+// evaluating a call too, in expanding macros and defining types.
+func TestRunErrors(t *testing.T) {
+	tests := []string{
+		"(= 1 2 3)",
+		"(< 1)",
+		"(= x y z)",
+		"(quote 1 2)",
+		"(: f (lambda (list a &rest) a)) (f 1)",
+		"(match 1 ((+ a) 1))",
+		"(defrecord r)",
+		"(deftype t (list 1))",
+		"(macro m (list a))",
+		"(macro m (list a) (+ y 1)) (m 2)",
+		"{1 2 3}",
+	}
+	for _, test := range tests {
+		if _, err := Run(parser.Parse(test, "test"), Options{}); err == nil {
+			t.Errorf("Run(%s) returned no error", test)
+		}
+	}
+	if _, err := Run(parser.Parse("(= 1 2) (< 1 2)", "test"), Options{}); err != nil {
+		t.Errorf("Run returned %s", err)
+	}
+}
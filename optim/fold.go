@@ -0,0 +1,116 @@
+package optim
+
+import (
+	"math"
+
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+)
+
+// commutative operations, whose first operand can be dropped when it is
+// an identity
+var commutative = map[token.ItemType]bool{
+	token.ItemAdd: true,
+	token.ItemMul: true,
+}
+
+// isIdentity reports whether x op n is x for every x. Adding +0 is not,
+// as it turns a -0 into 0, but adding -0 and subtracting +0 are.
+func isIdentity(key token.ItemType, n float64) bool {
+	switch key {
+	case token.ItemAdd:
+		return n == 0 && math.Signbit(n)
+	case token.ItemSub:
+		return n == 0 && !math.Signbit(n)
+	case token.ItemMul, token.ItemDiv:
+		return n == 1
+	}
+	return false
+}
+
+// Fold folds constant subexpressions of tree and applies the identities
+// (- x 0) -> x, (* x 1) -> x and (/ x 1) -> x when x is an arithmetic
+// operation, leaving unknown variables symbolic. The tree is rewritten in place and the (possibly new) root
+// is returned.
+//
+// Folding only uses the evaluator's own arithmetic, in the order it
+// evaluates operands, so a folded tree evaluates to exactly the same
+// value as the original. The leading constants of an n-ary operation are
+// combined, as (+ 1 2 x 3) is ((1 + 2) + x) + 3, but constants after an
+// unknown operand are not, as floating point addition and multiplication
+// aren't associative. For the same reason (+ x 0) is left alone: x could
+// be -0. An identity isn't applied to a variable, which could hold a
+// string that the operation would reject.
+func Fold(tree *ast.Tree) *ast.Tree {
+	return fold(tree, new(bool))
+}
+
+// fold is Fold, setting changed if it folds anything.
+func fold(tree *ast.Tree, changed *bool) *ast.Tree {
+	for i := 0; i < len(tree.Sub); i++ {
+		tree.Sub[i] = fold(tree.Sub[i], changed)
+	}
+	if tree.Val == nil || tree.Val.Typ != ast.ItemKey {
+		return tree
+	}
+	switch tree.Val.Key {
+	case token.ItemAdd, token.ItemMul, token.ItemSub, token.ItemDiv:
+		return foldLeft(tree, changed)
+	case token.ItemEq, token.ItemLt, token.ItemGt, token.ItemGe, token.ItemLe:
+		if len(tree.Sub) == 2 && onlyNums(tree) {
+			*changed = true
+			return evalLookup[tree.Val.Key](tree)
+		}
+	}
+	return tree
+}
+
+// foldLeft folds an operation evaluated from left to right, combining
+// its leading constant operands and dropping identity operands.
+func foldLeft(tree *ast.Tree, changed *bool) *ast.Tree {
+	if len(tree.Sub) == 0 {
+		return tree
+	}
+	op := evalLookup[tree.Val.Key]
+	if onlyNums(tree) {
+		*changed = true
+		return op(tree)
+	}
+	sub := tree.Sub
+	n := 0
+	for n < len(sub) && sub[n].Val.Typ == ast.ItemNum {
+		n++
+	}
+	if n > 1 {
+		*changed = true
+		sub = append([]*ast.Tree{op(&ast.Tree{Val: tree.Val, Sub: sub[:n]})}, sub[n:]...)
+	}
+	var kept []*ast.Tree
+	for i, t := range sub {
+		if t.Val.Typ == ast.ItemNum && isIdentity(tree.Val.Key, t.Val.Num) && (i > 0 || commutative[tree.Val.Key]) {
+			continue
+		}
+		kept = append(kept, t)
+	}
+	if len(kept) == 1 && !numeric(kept[0]) {
+		// the operation is what rejects a string
+		kept = sub
+	}
+	if len(kept) < len(sub) {
+		*changed = true
+	}
+	if len(kept) == 1 && numeric(kept[0]) {
+		return kept[0]
+	}
+	tree.Sub = kept
+	return tree
+}
+
+// numeric reports whether tree gives a number, if it gives anything: a
+// number or an arithmetic operation.
+func numeric(tree *ast.Tree) bool {
+	if tree.Val.Typ == ast.ItemNum {
+		return true
+	}
+	return isKey(tree, token.ItemAdd) || isKey(tree, token.ItemSub) || isKey(tree, token.ItemMul) || isKey(tree, token.ItemDiv)
+}
@@ -0,0 +1,146 @@
+package optim
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/parser"
+)
+
+// form parses the single form in s.
+func form(t *testing.T, s string) *ast.Tree {
+	tree := parser.Parse(s, "test")
+	if len(tree.Sub) != 1 {
+		t.Fatalf("%q: parsed %d forms, want 1", s, len(tree.Sub))
+	}
+	return tree.Sub[0]
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"(+ 1 2 3)", "6"},
+		{"(- 10 1 2)", "7"},
+		{"(+ 1 2 x)", "+{3, (x)}"},
+		{"(* 2 3 x 4)", "*{6, (x), 4}"},
+		// constants after an unknown aren't reassociated
+		{"(+ x 1 2)", "+{(x), 1, 2}"},
+		{"(+ 1 x 2)", "+{1, (x), 2}"},
+		// identities
+		{"(- (+ x 2) 0)", "+{(x), 2}"},
+		{"(* (+ x 2) 1)", "+{(x), 2}"},
+		{"(* 1 (+ x 2))", "+{(x), 2}"},
+		{"(/ (+ x 2) 1)", "+{(x), 2}"},
+		{"(- x 0 y)", "-{(x), (y)}"},
+		// but not to a variable, which could hold a string
+		{"(- x 0)", "-{(x), 0}"},
+		{"(* x 1)", "*{(x), 1}"},
+		{"(* 1 x)", "*{1, (x)}"},
+		{"(/ x 1)", "/{(x), 1}"},
+		{"(- 0 x)", "-{0, (x)}"},
+		{"(/ 1 x)", "/{1, (x)}"},
+		// (+ x 0) is -0 + 0 = 0 for x = -0
+		{"(+ x 0)", "+{(x), 0}"},
+		{"(+ 0 x)", "+{0, (x)}"},
+		// nested
+		{"(+ x (* 2 3))", "+{(x), 6}"},
+		{"(* (- x 0) 1)", "-{(x), 0}"},
+		{"(< 1 2)", "1"},
+		{"(= 1 2)", "0"},
+		{"(< x 2)", "<{(x), 2}"},
+		{"(> 1 2)", "0"},
+		{"(>= 2 2)", "1"},
+		{"(<= 3 2)", "0"},
+		{"(+)", "+"},
+	}
+	for _, test := range tests {
+		if got := Fold(form(t, test.in)).String(); got != test.want {
+			t.Errorf("Fold(%s) = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+// TestFoldExact checks that folding doesn't change the value of a form,
+// by folding it with x unknown and then evaluating it with x bound.
+func TestFoldExact(t *testing.T) {
+	tests := []struct {
+		in string
+		x  float64
+	}{
+		{"(+ x 1e16 1)", 1},
+		{"(+ 1 x 1e16)", -1e16},
+		{"(* 1e308 x 10)", 0.1},
+		{"(+ x 0)", math.Copysign(0, -1)},
+		{"(- x 0.1 0.2)", 0.3},
+	}
+	for _, test := range tests {
+		bind := func() *ast.Tree {
+			tree := parser.Parse("(: x 0) "+test.in, "test")
+			tree.Sub[0].Sub[1].Val.Num = test.x
+			return tree
+		}
+		want := Eval(bind()).Sub[1]
+		tree := bind()
+		tree.Sub[1] = Fold(tree.Sub[1])
+		got := Eval(tree).Sub[1]
+		if got.Val.Num != want.Val.Num || math.Signbit(got.Val.Num) != math.Signbit(want.Val.Num) {
+			t.Errorf("%s with x = %v: folded to %s, want %s", test.in, test.x, got, want)
+		}
+	}
+}
+
+// TestFoldStrings checks that an identity applied to a string is still
+// the error it is without folding, as it is with the inline pass.
+func TestFoldStrings(t *testing.T) {
+	const in = `(: f (lambda (list x) (* x 1))) (f "a")`
+	if _, err := Run(parser.Parse(in, "test"), Options{}); err == nil {
+		t.Errorf("%s returned no error", in)
+	}
+	pm, _ := NewPassManager("inline", "fold")
+	if _, err := Run(pm.Run(parser.Parse(in, "test")), Options{}); err == nil {
+		t.Errorf("%s inlined and folded returned no error", in)
+	}
+}
+
+func TestOnlyNums(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"(+ 1 2)", true},
+		{"(+ 1)", true},
+		{"(+)", false},
+		{"(+ 1 x)", false},
+		{`(+ 1 "a")`, false},
+		{"(+ 1 (+ 2 3))", false},
+	}
+	for _, test := range tests {
+		if got := onlyNums(form(t, test.in)); got != test.want {
+			t.Errorf("onlyNums(%s) = %v, want %v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestEvalCmp(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"(cmp (< 0 1) 2 3)", "2"},
+		{"(cmp (< 1 0) 2 3)", "3"},
+		{"(cmp 0 2 3)", "3"},
+		{"(cmp 5 2 3)", "3"},
+		// the condition is simplified, the branches kept as they are
+		{"(cmp (< x (+ 1 2)) 2 3)", "cmp{<{(x), 3}, 2, 3}"},
+		{"(cmp x (+ 1 2) 3)", "cmp{(x), +{1, 2}, 3}"},
+		// only the branch taken is evaluated
+		{"(cmp 1 2 (car (list)))", "2"},
+	}
+	for _, test := range tests {
+		tree := parser.Parse(test.in, "test")
+		if got := Eval(tree).Sub[0].String(); got != test.want {
+			t.Errorf("%s = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
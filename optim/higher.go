@@ -0,0 +1,168 @@
+package optim
+
+import (
+	"sort"
+
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+)
+
+// invoke calls the function value fn with args, giving nil unless the
+// result is a value.
+func (scope *Scope) invoke(name string, fn *ast.Tree, args []*ast.Tree) *ast.Tree {
+	t := scope.lambda(name, ast.CopyTree(fn, new(ast.Tree)), args)
+	if t == nil || !isValue(t) {
+		return nil
+	}
+	return t
+}
+
+// functionAnd checks that args are a function followed by n lists,
+// reporting an error for name otherwise.
+func (scope *Scope) functionAnd(name string, args []*ast.Tree, n int) bool {
+	if len(args) != n+1 || !isFunction(args[0]) {
+		if n == 1 {
+			scope.errorf("%s takes a function and a list", name)
+		} else {
+			scope.errorf("%s takes a function and %d lists", name, n)
+		}
+		return false
+	}
+	return scope.lists(name, args[1:], n)
+}
+
+// (map f l...) calls f with the elements of the lists at each position,
+// up to the end of the shortest.
+func evalMap(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) < 2 {
+		scope.errorf("map takes a function and lists")
+		return nil
+	}
+	if !scope.functionAnd("map", args, len(args)-1) {
+		return nil
+	}
+	n := len(args[1].Sub)
+	for _, l := range args[2:] {
+		if len(l.Sub) < n {
+			n = len(l.Sub)
+		}
+	}
+	elems := make([]*ast.Tree, n)
+	for i := 0; i < n; i++ {
+		var xs []*ast.Tree
+		for _, l := range args[1:] {
+			xs = append(xs, l.Sub[i])
+		}
+		if elems[i] = scope.invoke("map", args[0], xs); elems[i] == nil {
+			return nil
+		}
+	}
+	return newList(elems)
+}
+
+func evalFilter(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if !scope.functionAnd("filter", args, 1) {
+		return nil
+	}
+	var elems []*ast.Tree
+	for _, x := range args[1].Sub {
+		t := scope.invoke("filter", args[0], []*ast.Tree{x})
+		if t == nil {
+			return nil
+		}
+		if t.Val.Typ == ast.ItemNum && t.Val.Num == 1 {
+			elems = append(elems, x)
+		}
+	}
+	return newList(elems)
+}
+
+// (reduce f init l) folds l from the left with f, starting from init, or
+// from the first element of l if there is no init.
+func evalReduce(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) == 2 {
+		if !scope.functionAnd("reduce", args, 1) {
+			return nil
+		}
+		if len(args[1].Sub) == 0 {
+			scope.errorf("reduce of empty list with no initial value")
+			return nil
+		}
+		args = []*ast.Tree{args[0], args[1].Sub[0], newList(args[1].Sub[1:])}
+	}
+	if len(args) != 3 || !isFunction(args[0]) || !isList(args[2]) {
+		scope.errorf("reduce takes a function, an optional initial value and a list")
+		return nil
+	}
+	acc := args[1]
+	for _, x := range args[2].Sub {
+		if acc = scope.invoke("reduce", args[0], []*ast.Tree{acc, x}); acc == nil {
+			return nil
+		}
+	}
+	return acc
+}
+
+// (apply f args... l) calls f with args followed by the elements of l.
+func evalApply(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) < 2 || !isFunction(args[0]) || !isList(args[len(args)-1]) {
+		scope.errorf("apply takes a function, its first arguments and a list of the rest")
+		return nil
+	}
+	xs := append([]*ast.Tree(nil), args[1:len(args)-1]...)
+	xs = append(xs, args[len(args)-1].Sub...)
+	return scope.invoke("apply", args[0], xs)
+}
+
+// (compose f g...) gives the function calling the last function with
+// its arguments and each function before with the result of the one
+// after it.
+func evalCompose(scope *Scope, args []*ast.Tree) *ast.Tree {
+	for _, f := range args {
+		if !isFunction(f) {
+			scope.errorf("compose of non-function %s", f)
+			return nil
+		}
+	}
+	xs := &ast.Tree{Val: &ast.Node{Typ: ast.ItemVar, Var: fresh("xs")}}
+	if len(args) == 0 {
+		// identity
+		return call(token.ItemFunction, call(token.ItemList, xs), ast.CopyTree(xs, new(ast.Tree)))
+	}
+	last := len(args) - 1
+	body := call(token.ItemApply, ast.CopyTree(args[last], new(ast.Tree)), ast.CopyTree(xs, new(ast.Tree)))
+	for i := last - 1; i >= 0; i-- {
+		body = call(token.ItemApply, ast.CopyTree(args[i], new(ast.Tree)), call(token.ItemList, body))
+	}
+	rest := &ast.Tree{Val: &ast.Node{Typ: ast.ItemVar, Var: "&rest"}}
+	return call(token.ItemFunction, call(token.ItemList, rest, xs), body)
+}
+
+// (sort-by key l) sorts l stably by the result of key on each element,
+// which must be all numbers or all strings.
+func evalSortBy(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if !scope.functionAnd("sort-by", args, 1) {
+		return nil
+	}
+	elems := append([]*ast.Tree(nil), args[1].Sub...)
+	keys := make(map[*ast.Tree]*ast.Tree)
+	for _, x := range elems {
+		k := scope.invoke("sort-by", args[0], []*ast.Tree{x})
+		if k == nil {
+			return nil
+		}
+		if k.Val.Typ != ast.ItemNum && k.Val.Typ != ast.ItemString || len(keys) > 0 && k.Val.Typ != keys[elems[0]].Val.Typ {
+			scope.errorf("sort-by keys must be all numbers or all strings, got %s", k)
+			return nil
+		}
+		keys[x] = k
+	}
+	sort.SliceStable(elems, func(i, j int) bool {
+		a, b := keys[elems[i]].Val, keys[elems[j]].Val
+		if a.Typ == ast.ItemNum {
+			return a.Num < b.Num
+		}
+		return a.Str < b.Str
+	})
+	return newList(elems)
+}
@@ -0,0 +1,34 @@
+package optim
+
+import "testing"
+
+func TestHigherOrder(t *testing.T) {
+	const double = "(: double (lambda (list x) (* x 2))) "
+	runAll(t, Options{}, []runTest{
+		{in: double + "(map double (list 1 2 3))", want: "list{2, 4, 6}"},
+		{in: double + "(map double (list))", want: "list{}"},
+		{in: "(map (lambda (list a b) (+ a b)) (list 1 2) (list 10 20))", want: "list{11, 22}"},
+		{in: "(filter (lambda (list x) (< x 2)) (list 1 2 3 0))", want: "list{1, 0}"},
+		{in: "(filter (lambda (list x) 1) (list))", want: "list{}"},
+		{in: "(reduce (lambda (list a x) (+ a x)) 0 (list 1 2 3))", want: "6"},
+		{in: "(reduce (lambda (list a x) (+ a x)) 0 (list))", want: "0"},
+		{in: "(reduce (lambda (list a x) (- a x)) (list 10 1 2))", want: "7"},
+		{in: "(apply (lambda (list a b c) (+ a b c)) 1 (list 2 3))", want: "6"},
+		{in: "(apply (lambda (list) 7) (list))", want: "7"},
+		{in: double + "((compose double (lambda (list x) (+ x 1))) 3)", want: "8"},
+		{in: "((compose) 3)", want: "3"},
+		{in: "(sort-by (lambda (list x) (- 0 x)) (list 1 3 2))", want: "list{3, 2, 1}"},
+		{in: "(sort-by (lambda (list x) x) (list))", want: "list{}"},
+		// sort-by is stable
+		{in: "(sort-by (lambda (list p) (car p)) (list (list 1 :a) (list 0 :b) (list 1 :c)))", want: "list{list{0, :b}, list{1, :a}, list{1, :c}}"},
+		// errors
+		{in: "(reduce (lambda (list a x) (- a x)) (list))", err: true},
+		{in: "(map 1 (list 1))", err: true},
+		{in: "(filter (lambda (list x) x) 1)", err: true},
+		{in: "(reduce 1 0 (list 1))", err: true},
+		{in: "(apply 1 (list))", err: true},
+		{in: "(apply (lambda (list a) a) 1)", err: true},
+		{in: "(compose 1)", err: true},
+		{in: "(sort-by 2 (list 1))", err: true},
+	})
+}
@@ -0,0 +1,387 @@
+package optim
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+)
+
+// Inliner substitutes calls of small lambdas by their bodies.
+type Inliner struct {
+	MaxSize    int // largest lambda body, in nodes, that is inlined
+	MaxArgSize int // largest argument, in nodes, that may be duplicated
+}
+
+// DefaultInliner only inlines small bodies and never duplicates
+// anything bigger than an atom.
+var DefaultInliner = Inliner{
+	MaxSize:    16,
+	MaxArgSize: 1,
+}
+
+// Decision records whether, and why, a lambda was inlined at a call.
+type Decision struct {
+	Name    string    // lambda name
+	Size    int       // size of the lambda body in nodes
+	Call    *ast.Tree // call site, nil if the lambda was never considered
+	Inlined bool
+	Reason  string
+}
+
+func (d Decision) String() string {
+	s := fmt.Sprintf("%s (size %d)", d.Name, d.Size)
+	if d.Call != nil {
+		s += fmt.Sprintf(" at %s", d.Call)
+	}
+	if d.Inlined {
+		return s + ": inlined"
+	}
+	return s + ": not inlined, " + d.Reason
+}
+
+// Inline inlines small lambdas with the DefaultInliner.
+func Inline(tree *ast.Tree) (*ast.Tree, []Decision) {
+	return DefaultInliner.Inline(tree)
+}
+
+// Inline substitutes the body of every small, non-recursive lambda
+// assigned once at the top level of the program for each call after its
+// assign, renaming binders in the body that would capture the
+// arguments. Lambdas and calls are only inlined when doing so cannot
+// change what a name resolves to under dynamic scoping. Arguments that
+// could have an effect stay where they were, bound by a let around the
+// body under fresh names. The tree is rewritten in place and
+// a decision is reported for each lambda and call considered.
+func (in Inliner) Inline(tree *ast.Tree) (*ast.Tree, []Decision) {
+	var ds []Decision
+	if tree.Val != nil {
+		return tree, ds
+	}
+	assigns := countAssigns(tree)
+	defs := make(map[string]*ast.Tree)
+	for i := 0; i < len(tree.Sub); i++ {
+		if name, fn := lambdaAssign(tree.Sub[i]); fn != nil && assigns[name] == 1 {
+			defs[name] = fn
+		}
+	}
+	for i := 0; i < len(tree.Sub); i++ {
+		name, fn := lambdaAssign(tree.Sub[i])
+		if fn == nil || defs[name] == nil {
+			continue
+		}
+		d := Decision{Name: name, Size: size(fn.Sub[1])}
+		if d.Reason = in.check(tree, name, defs); d.Reason != "" {
+			ds = append(ds, d)
+			continue
+		}
+		for j := i + 1; j < len(tree.Sub); j++ {
+			tree.Sub[j] = in.inlineCalls(tree.Sub[j], d, fn, &ds)
+		}
+	}
+	return tree, ds
+}
+
+// lambdaAssign returns the name and lambda of an assign of a lambda.
+func lambdaAssign(t *ast.Tree) (string, *ast.Tree) {
+	if !isKey(t, token.ItemAssign) || len(t.Sub) != 2 || t.Sub[0].Val.Typ != ast.ItemVar {
+		return "", nil
+	}
+	fn := t.Sub[1]
+	if !isKey(fn, token.ItemFunction) || len(fn.Sub) != 2 {
+		return "", nil
+	}
+	return t.Sub[0].Val.Var, fn
+}
+
+// check returns why the lambda name must not be inlined anywhere, or
+// "" if it may be.
+func (in Inliner) check(tree *ast.Tree, name string, defs map[string]*ast.Tree) string {
+	fn := defs[name]
+	body := fn.Sub[1]
+	switch {
+	case size(body) > in.MaxSize:
+		return "too large"
+	case recursive(name, defs):
+		return "recursive"
+	case sideEffects(body):
+		return "side effects"
+	case binds(body):
+		return "binds variables with let or match"
+	case !simple(fn.Sub[0]):
+		return "optional, rest, pattern or annotated parameters"
+	}
+	// other lambdas may read parameters through dynamic scope
+	dyn := make(map[string]bool)
+	walk(tree, func(t *ast.Tree) bool {
+		if t == fn {
+			return false
+		}
+		if isKey(t, token.ItemFunction) && len(t.Sub) == 2 {
+			for v := range freeVars(t) {
+				dyn[v] = true
+			}
+		}
+		return true
+	})
+	params := fn.Sub[0].Sub
+	for i := 0; i < len(params); i++ {
+		p := params[i].Val.Var
+		if dyn[p] {
+			return "parameter " + p + " is read dynamically"
+		}
+		// lambda values in the body are not closures, they must keep
+		// referring to the parameter by name
+		captured := false
+		walk(body, func(t *ast.Tree) bool {
+			if isKey(t, token.ItemFunction) && len(t.Sub) == 2 && freeVars(t)[p] {
+				captured = true
+			}
+			return !captured
+		})
+		if captured {
+			return "parameter " + p + " is captured by a lambda value"
+		}
+	}
+	return ""
+}
+
+// recursive reports whether the lambda name can reach itself through
+// the lambdas it calls.
+func recursive(name string, defs map[string]*ast.Tree) bool {
+	seen := make(map[string]bool)
+	var reach func(n string) bool
+	reach = func(n string) bool {
+		refs := make(map[string]int)
+		countRefs(defs[n].Sub[1], refs)
+		for r := range refs {
+			if r == name || r == "self" {
+				return true
+			}
+			if defs[r] != nil && !seen[r] {
+				seen[r] = true
+				if reach(r) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return reach(name)
+}
+
+// inlineCalls inlines the lambda fn at each call of it in tree.
+func (in Inliner) inlineCalls(tree *ast.Tree, d Decision, fn *ast.Tree, ds *[]Decision) *ast.Tree {
+	for _, b := range binders(tree) {
+		if b.Val.Var == d.Name && !isKey(tree, token.ItemAssign) {
+			// the name means something else in here
+			return tree
+		}
+	}
+	start := 0
+	if isKey(tree, token.ItemAssign) || isKey(tree, token.ItemFunction) {
+		start = 1 // binders
+	}
+	for i := start; i < len(tree.Sub); i++ {
+		if isKey(tree, token.ItemMatch) && i%2 == 1 {
+			continue // patterns aren't calls
+		}
+		tree.Sub[i] = in.inlineCalls(tree.Sub[i], d, fn, ds)
+	}
+	if !isKey(tree, token.ItemLambda) || tree.Val.Var != d.Name {
+		return tree
+	}
+	d.Call = ast.CopyTree(tree, new(ast.Tree))
+	if d.Reason = in.checkCall(tree, fn); d.Reason != "" {
+		*ds = append(*ds, d)
+		return tree
+	}
+	d.Inlined = true
+	*ds = append(*ds, d)
+	params := fn.Sub[0].Sub
+	keep := kept(tree.Sub)
+	env := make(map[string]*ast.Tree)
+	free := make(map[string]int)
+	var lets []*ast.Tree
+	for i := 0; i < len(params); i++ {
+		p, arg := params[i].Val.Var, tree.Sub[i]
+		if keep[i] {
+			q := fresh(p)
+			lets = append(lets, &ast.Tree{
+				Val: &ast.Node{Typ: ast.ItemKey, Key: token.ItemLambda, Var: q},
+				Sub: []*ast.Tree{arg},
+			})
+			env[p] = ref(q)
+			continue
+		}
+		env[p] = arg
+		countRefs(arg, free)
+	}
+	body := ast.CopyTree(fn.Sub[1], new(ast.Tree))
+	avoidCapture(body, free)
+	body = substitute(body, env)
+	if lets == nil {
+		return body
+	}
+	return call(token.ItemLet, call(token.ItemList, lets...), body)
+}
+
+// kept reports which of the arguments of an inlined call are bound by a
+// let rather than substituted, so that they are evaluated once, before
+// the body and in order, as they are in the call. If none of them could
+// have an effect they all are substituted, otherwise those that could
+// and those whose value an effect could change are kept.
+func kept(args []*ast.Tree) []bool {
+	keep := make([]bool, len(args))
+	effect := false
+	for _, a := range args {
+		effect = effect || !inert(a)
+	}
+	for i, a := range args {
+		keep[i] = effect && (!inert(a) || len(vars(a)) > 0)
+	}
+	return keep
+}
+
+// checkCall returns why a call must not be inlined, or "" if it may be.
+func (in Inliner) checkCall(call *ast.Tree, fn *ast.Tree) string {
+	params := fn.Sub[0].Sub
+	if len(call.Sub) != len(params) {
+		return "wrong number of arguments"
+	}
+	uses := make(map[string]int)
+	countRefs(fn.Sub[1], uses)
+	keep := kept(call.Sub)
+	for i := 0; i < len(params); i++ {
+		arg, p := call.Sub[i], params[i].Val.Var
+		switch {
+		case keep[i]:
+		case uses[p] > 1 && size(arg) > in.MaxArgSize:
+			return "argument " + p + " too large to duplicate"
+		case calledAs(fn.Sub[1], p) && arg.Val.Typ != ast.ItemVar:
+			return "argument " + p + " is called but is not a name"
+		}
+	}
+	return ""
+}
+
+// binds reports whether tree contains a let or match.
+func binds(tree *ast.Tree) bool {
+	found := false
+	walk(tree, func(t *ast.Tree) bool {
+		found = found || isLet(t) || isKey(t, token.ItemMatch)
+		return !found
+	})
+	return found
+}
+
+// calledAs reports whether name is called as a lambda in tree.
+func calledAs(tree *ast.Tree, name string) bool {
+	found := false
+	walk(tree, func(t *ast.Tree) bool {
+		found = found || isKey(t, token.ItemLambda) && t.Val.Var == name
+		return !found
+	})
+	return found
+}
+
+// avoidCapture renames the parameters of lambdas in tree that would
+// bind any of the names in free.
+func avoidCapture(tree *ast.Tree, free map[string]int) {
+	walk(tree, func(t *ast.Tree) bool {
+		if !isKey(t, token.ItemFunction) || len(t.Sub) < 2 {
+			return true
+		}
+		params := paramNames(t.Sub[0])
+		for i := 0; i < len(params); i++ {
+			if p := params[i].Val.Var; free[p] > 0 {
+				q := fresh(p)
+				params[i].Val.Var = q
+				t.Sub[1] = substitute(t.Sub[1], map[string]*ast.Tree{
+					p: {Val: &ast.Node{Typ: ast.ItemVar, Var: q}},
+				})
+			}
+		}
+		return true
+	})
+}
+
+// substitute replaces the variables in env by copies of their values,
+// respecting lambda parameters that shadow them.
+func substitute(tree *ast.Tree, env map[string]*ast.Tree) *ast.Tree {
+	switch {
+	case tree.Val == nil:
+	case tree.Val.Typ == ast.ItemVar:
+		if v, ok := env[tree.Val.Var]; ok {
+			return ast.CopyTree(v, new(ast.Tree))
+		}
+		return tree
+	case isKey(tree, token.ItemLambda):
+		// functions passed as arguments are always names
+		if v, ok := env[tree.Val.Var]; ok {
+			tree.Val.Var = v.Val.Var
+		}
+	case isKey(tree, token.ItemAssign):
+		for i := 1; i < len(tree.Sub); i++ {
+			tree.Sub[i] = substitute(tree.Sub[i], env)
+		}
+		return tree
+	case isKey(tree, token.ItemFunction) && len(tree.Sub) > 1:
+		inner := make(map[string]*ast.Tree)
+		for k, v := range env {
+			inner[k] = v
+		}
+		for _, p := range paramNames(tree.Sub[0]) {
+			delete(inner, p.Val.Var)
+		}
+		tree.Sub[1] = substitute(tree.Sub[1], inner)
+		return tree
+	}
+	for i := 0; i < len(tree.Sub); i++ {
+		tree.Sub[i] = substitute(tree.Sub[i], env)
+	}
+	return tree
+}
+
+// freeVars returns the names a lambda references but does not bind.
+func freeVars(fn *ast.Tree) map[string]bool {
+	free := make(map[string]bool)
+	if len(fn.Sub) < 2 {
+		return free
+	}
+	refs := make(map[string]int)
+	countRefs(fn.Sub[1], refs)
+	for _, p := range fn.Sub[0].Sub {
+		// defaults are evaluated with the parameters bound too
+		if isOptional(p) {
+			countRefs(p.Sub[0], refs)
+		}
+	}
+	for _, p := range paramNames(fn.Sub[0]) {
+		delete(refs, p.Val.Var)
+	}
+	for v := range refs {
+		free[v] = true
+	}
+	return free
+}
+
+// size counts the nodes in tree.
+func size(tree *ast.Tree) int {
+	n := 0
+	walk(tree, func(*ast.Tree) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+var freshCount int64
+
+// fresh returns a new name based on name. The '#' it contains cannot
+// appear in a lexed name, so it never clashes with user code.
+func fresh(name string) string {
+	return name + "#" + strconv.FormatInt(atomic.AddInt64(&freshCount, 1), 10)
+}
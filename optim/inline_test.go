@@ -0,0 +1,38 @@
+package optim
+
+import (
+	"testing"
+
+	"github.com/cptaffe/lang/parser"
+)
+
+func TestInlineKeepsArguments(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		// an argument that throws, for a parameter the body doesn't use
+		{`(: f (lambda (list a b) b))
+		  (try (f (car (list)) 2) (catch e 99))`, "99"},
+		{`(: f (lambda (list a b) b))
+		  (try (f 1 (throw "no")) (catch e 99))`, "99"},
+		// an argument used twice
+		{`(: f (lambda (list a) (+ a a)))
+		  (f (car (list 3)))`, "6"},
+		{`(: f (lambda (list a b) (* a b))) (f 2 3)`, "6"},
+	}
+	for _, test := range tests {
+		if got := optimized(t, test.in, "inline"); got != test.want {
+			t.Errorf("%s = %s after inline, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestInlineSubstitutes(t *testing.T) {
+	tree, _ := Inline(parser.Parse(`(: f (lambda (list a b) (+ a b))) (f x 2) (f (car y) 2)`, "test"))
+	if got := tree.Sub[1].String(); got != "+{(x), 2}" {
+		t.Errorf("(f x 2) inlined to %s, want +{(x), 2}", got)
+	}
+	if got := tree.Sub[2]; !isLet(got) {
+		t.Errorf("(f (car y) 2) inlined to %s, want a let", got)
+	}
+}
@@ -0,0 +1,67 @@
+package optim
+
+import (
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+	"github.com/cptaffe/lang/variable"
+)
+
+// isLet reports whether tree is a let, let* or letrec.
+func isLet(tree *ast.Tree) bool {
+	return isKey(tree, token.ItemLet) || isKey(tree, token.ItemLetStar) || isKey(tree, token.ItemLetrec)
+}
+
+// evalLet evaluates (let (list (name value)...) body), binding the
+// names in a child scope that only the body sees. let evaluates the
+// values in the enclosing scope, let* evaluates each one with the names
+// before it bound, and letrec binds every name before evaluating any
+// value, so lambdas bound by it can call each other.
+func (scope *Scope) evalLet(tree *ast.Tree) *ast.Tree {
+	if len(tree.Sub) != 2 || !isKey(tree.Sub[0], token.ItemList) {
+		scope.errorf("incorrect %s syntax %s", tree.Val.Var, tree)
+		return nil
+	}
+	bindings := tree.Sub[0].Sub
+	for _, b := range bindings {
+		if !isKey(b, token.ItemLambda) || len(b.Sub) != 1 {
+			scope.errorf("incorrect %s binding %s", tree.Val.Var, b)
+			return nil
+		}
+	}
+	sc := scope.childScope()
+	switch tree.Val.Key {
+	case token.ItemLet:
+		vals := make([]*ast.Tree, len(bindings))
+		for i, b := range bindings {
+			vals[i] = scope.value(b.Sub[0])
+		}
+		for i, b := range bindings {
+			sc.Add(&variable.Var{Var: b.Val.Var, Tree: vals[i]})
+		}
+	case token.ItemLetStar:
+		for i, b := range bindings {
+			if i > 0 {
+				// a scope per binding, which can rebind a name before it
+				sc = sc.childScope()
+			}
+			sc.Add(&variable.Var{Var: b.Val.Var, Tree: sc.value(b.Sub[0])})
+		}
+	case token.ItemLetrec:
+		vars := make([]*variable.Var, len(bindings))
+		for i, b := range bindings {
+			vars[i] = &variable.Var{Var: b.Val.Var, Tree: b.Sub[0]}
+			sc.Add(vars[i])
+		}
+		for i, b := range bindings {
+			if !isKey(b.Sub[0], token.ItemFunction) {
+				vars[i].Tree = sc.value(b.Sub[0])
+			}
+		}
+	}
+	// a body that doesn't reduce to a value is left alone, its names
+	// would mean something else outside the let
+	if t := sc.value(ast.CopyTree(tree.Sub[1], new(ast.Tree))); isValue(t) {
+		return t
+	}
+	return nil
+}
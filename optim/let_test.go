@@ -0,0 +1,30 @@
+package optim
+
+import "testing"
+
+func TestLet(t *testing.T) {
+	const evenOdd = "(letrec (list" +
+		" (ev (lambda (list n) (cmp (= n 0) 1 (od (- n 1)))))" +
+		" (od (lambda (list n) (cmp (= n 0) 0 (ev (- n 1))))))"
+	runAll(t, Options{}, []runTest{
+		{in: "(let (list (x 1) (y 2)) (+ x y))", want: "3"},
+		// let bindings don't see each other
+		{in: "(: x 10) (let (list (x 1) (y x)) (+ x y))", want: "11"},
+		{in: "(let (list (a 1) (b (+ a 1))) b)", want: "let{list{unk{1}, unk{+{(a), 1}}}, (b)}"},
+		// and only the body sees them
+		{in: "(: x 10) (let (list (x 1)) x) (+ x 0)", want: "10"},
+		{in: "(let (list (x 1)) (let (list (x 2)) x))", want: "2"},
+		// let* binds in order
+		{in: "(let* (list (x 1) (y (+ x 1))) (* x y))", want: "2"},
+		{in: "(: n 5) (let* (list (n 1) (n (+ n 1))) n)", want: "2"},
+		// letrec lambdas can call each other
+		{in: evenOdd + " (list (ev 10) (od 7) (ev 3)))", want: "list{1, 1, 0}"},
+		{in: "(letrec (list (f (lambda (list n) (cmp (< n 1) 0 (+ n (f (- n 1))))))) (f 4))", want: "10"},
+		// errors
+		{in: "(let (list (x 1)))", err: true},
+		{in: "(let (list (x 1)) x x)", err: true},
+		{in: "(let 1 x)", err: true},
+		{in: "(let* (list x) x)", err: true},
+		{in: "(letrec (list (x 1 2)) x)", err: true},
+	})
+}
@@ -0,0 +1,116 @@
+package optim
+
+import (
+	"github.com/cptaffe/lang/ast"
+)
+
+// newList returns a list value holding elems.
+func newList(elems []*ast.Tree) *ast.Tree {
+	return &ast.Tree{
+		Val: &ast.Node{Typ: ast.ItemList},
+		Sub: elems,
+	}
+}
+
+// isList reports whether tree is a list value.
+func isList(tree *ast.Tree) bool {
+	return tree.Val.Typ == ast.ItemList
+}
+
+// lists checks that args are n lists, reporting an error for name
+// otherwise.
+func (scope *Scope) lists(name string, args []*ast.Tree, n int) bool {
+	if len(args) != n {
+		scope.errorf("%s takes %d arguments", name, n)
+		return false
+	}
+	for i := 0; i < n; i++ {
+		if !isList(args[i]) {
+			scope.errorf("%s of non-list %s", name, args[i])
+			return false
+		}
+	}
+	return true
+}
+
+func evalMakeList(scope *Scope, args []*ast.Tree) *ast.Tree {
+	return newList(append([]*ast.Tree(nil), args...))
+}
+
+func evalCons(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 2 || !isList(args[1]) {
+		scope.errorf("cons takes an element and a list")
+		return nil
+	}
+	return newList(append([]*ast.Tree{args[0]}, args[1].Sub...))
+}
+
+func evalCar(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if !scope.lists("car", args, 1) {
+		return nil
+	}
+	if len(args[0].Sub) == 0 {
+		scope.errorf("car of empty list")
+		return nil
+	}
+	return args[0].Sub[0]
+}
+
+func evalCdr(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if !scope.lists("cdr", args, 1) {
+		return nil
+	}
+	if len(args[0].Sub) == 0 {
+		scope.errorf("cdr of empty list")
+		return nil
+	}
+	return newList(append([]*ast.Tree(nil), args[0].Sub[1:]...))
+}
+
+func evalLength(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if !scope.lists("length", args, 1) {
+		return nil
+	}
+	return num(float64(len(args[0].Sub)))
+}
+
+func evalAppend(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if !scope.lists("append", args, len(args)) {
+		return nil
+	}
+	var elems []*ast.Tree
+	for i := 0; i < len(args); i++ {
+		elems = append(elems, args[i].Sub...)
+	}
+	return newList(elems)
+}
+
+// evalNth returns the n'th element of a list, counting from 0.
+func evalNth(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 2 || args[0].Val.Typ != ast.ItemNum || !isList(args[1]) {
+		scope.errorf("nth takes an index and a list")
+		return nil
+	}
+	n := args[0].Val.Num
+	if n != float64(int(n)) || n < 0 || int(n) >= len(args[1].Sub) {
+		scope.errorf("nth index %s out of range", args[0])
+		return nil
+	}
+	return args[1].Sub[int(n)]
+}
+
+func evalNull(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 1 {
+		scope.errorf("null? takes 1 argument")
+		return nil
+	}
+	return truth(isList(args[0]) && len(args[0].Sub) == 0)
+}
+
+func evalIsList(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 1 {
+		scope.errorf("list? takes 1 argument")
+		return nil
+	}
+	return truth(isList(args[0]))
+}
@@ -0,0 +1,453 @@
+package optim
+
+import (
+	"sync/atomic"
+
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+)
+
+// MaxExpansions bounds the expansions of one form, catching macros that
+// expand forever.
+const MaxExpansions = 1000
+
+// Expander expands macro calls, remembering the macros defined in the
+// trees it expands.
+type Expander struct {
+	macros map[string]*ast.Tree // macro lambdas by name
+	types  map[string]bool      // names of the types defined so far
+	scope  *Scope               // macro bodies run here
+}
+
+// NewExpander returns an Expander that knows no macros.
+func NewExpander() *Expander {
+	return &Expander{
+		macros: make(map[string]*ast.Tree),
+		types:  make(map[string]bool),
+		scope:  newScope(Options{}),
+	}
+}
+
+// Expand expands the macros in a program with a new Expander.
+func Expand(tree *ast.Tree) *ast.Tree {
+	return NewExpander().Expand(tree)
+}
+
+// Expand expands every macro call in tree, outside quoted data. Top
+// level (macro name (list params) body) forms define macros for the
+// rest of the tree and are removed from it. A macro is called with its
+// arguments as unevaluated data, and the data its body returns replaces
+// the call as code. (macroexpand 'form) is replaced by the quoted
+// expansion of form, for debugging. Top level defrecord and deftype
+// forms are replaced by the assigns they stand for. The tree is rewritten in
+// place.
+func (e *Expander) Expand(tree *ast.Tree) *ast.Tree {
+	if tree.Val != nil {
+		tree = e.expand(tree)
+		// marks only mean something while expanding
+		setMarks(tree, 0)
+		return tree
+	}
+	sub := make([]*ast.Tree, 0, len(tree.Sub))
+	for i := 0; i < len(tree.Sub); i++ {
+		if t := tree.Sub[i]; isKey(t, token.ItemMacro) {
+			e.define(t)
+		} else if isKey(t, token.ItemDefrecord) {
+			e.defineType(t)
+			sub = append(sub, e.scope.defrecord(t)...)
+		} else if isKey(t, token.ItemDeftype) {
+			e.defineType(t)
+			sub = append(sub, e.scope.deftype(t)...)
+		} else {
+			sub = append(sub, e.expand(t))
+		}
+	}
+	tree.Sub = sub
+	setMarks(tree, 0)
+	return tree
+}
+
+// MacroExpand expands form while it is a macro call, without expanding
+// anything inside it.
+func (e *Expander) MacroExpand(form *ast.Tree) *ast.Tree {
+	for n := 0; ; n++ {
+		fn := e.macro(form)
+		if fn == nil {
+			return form
+		}
+		if n == MaxExpansions {
+			e.scope.errorf("macro %s expands too many times", form.Val.Var)
+			return form
+		}
+		t := e.call(fn, form)
+		if t == nil {
+			return form
+		}
+		form = t
+	}
+}
+
+// define remembers a macro definition.
+func (e *Expander) define(tree *ast.Tree) {
+	if len(tree.Sub) != 3 || tree.Sub[0].Val.Typ != ast.ItemVar || !isKey(tree.Sub[1], token.ItemList) {
+		e.scope.errorf("incorrect macro syntax %s", tree)
+		return
+	}
+	// macros may use the macros before them
+	body := e.expand(tree.Sub[2])
+	e.macros[tree.Sub[0].Val.Var] = &ast.Tree{
+		Val: &ast.Node{Typ: ast.ItemKey, Key: token.ItemFunction},
+		Sub: []*ast.Tree{tree.Sub[1], body},
+	}
+}
+
+// macro returns the macro form calls, if it is a macro call.
+func (e *Expander) macro(form *ast.Tree) *ast.Tree {
+	if !isKey(form, token.ItemLambda) {
+		return nil
+	}
+	return e.macros[form.Val.Var]
+}
+
+// call runs the macro fn on the arguments of form, returning its
+// expansion as code.
+func (e *Expander) call(fn *ast.Tree, form *ast.Tree) *ast.Tree {
+	sig := e.scope.params(fn.Sub[0])
+	if sig == nil || !sig.check(e.scope, "macro "+form.Val.Var, len(form.Sub)) {
+		return nil
+	}
+	// everything the caller wrote is marked, the rest of the expansion
+	// was introduced by the macro
+	m := int(atomic.AddInt64(&markCount, 1))
+	sc := e.scope.childScope()
+	args := make([]*ast.Tree, len(form.Sub))
+	for i := 0; i < len(form.Sub); i++ {
+		args[i] = e.toData(form.Sub[i])
+		markArgs(args[i], m)
+	}
+	if !sig.bind("macro "+form.Val.Var, sc, args) {
+		return nil
+	}
+	t := sc.force(ast.CopyTree(fn.Sub[1], new(ast.Tree)))
+	if t == nil || !isValue(t) {
+		e.scope.errorf("macro %s did not expand to data: %s", form.Val.Var, fn.Sub[1])
+		return nil
+	}
+	code := e.toCode(t)
+	rename(code, m)
+	markFree(code, m)
+	return code
+}
+
+var markCount int64
+
+// introduced marks the references a macro expansion introduced that
+// name something where the macro is defined, until the whole tree is
+// expanded, so that unshadow can keep the caller's binders from
+// capturing them.
+const introduced = -1
+
+// markArgs marks every node in the arguments of a macro call with m,
+// except those an earlier expansion introduced.
+func markArgs(tree *ast.Tree, m int) {
+	walk(tree, func(t *ast.Tree) bool {
+		if t.Val != nil && t.Val.Mark != introduced {
+			t.Val.Mark = m
+		}
+		return true
+	})
+}
+
+// markFree marks the names in a renamed expansion that the macro
+// introduced with introduced, and clears the marks of the rest.
+func markFree(code *ast.Tree, m int) {
+	walk(code, func(t *ast.Tree) bool {
+		if t.Val == nil {
+			return true
+		}
+		if t.Val.Mark != m && (t.Val.Typ == ast.ItemVar || isKey(t, token.ItemLambda)) {
+			t.Val.Mark = introduced
+		} else {
+			t.Val.Mark = 0
+		}
+		return true
+	})
+}
+
+// unshadow renames the variables the form tree binds where they would
+// capture a reference that a macro expansion inside it introduced, as
+// that names what it does where the macro is defined. Scoping being
+// dynamic, lambdas tree calls can't read the renamed variables either.
+func unshadow(tree *ast.Tree) {
+	if isKey(tree, token.ItemAssign) {
+		return
+	}
+	bs := binders(tree)
+	if len(bs) == 0 {
+		return
+	}
+	free := make(map[string]bool)
+	walk(tree, func(t *ast.Tree) bool {
+		if t.Val != nil && t.Val.Mark == introduced {
+			free[t.Val.Var] = true
+		}
+		return true
+	})
+	for _, b := range bs {
+		name := b.Val.Var
+		if b.Val.Mark == introduced || !free[name] {
+			continue
+		}
+		to := fresh(name)
+		switch {
+		case isKey(tree, token.ItemLet):
+			// values are evaluated outside of it
+			b.Val.Var = to
+			for i := 1; i < len(tree.Sub); i++ {
+				renameRefs(tree.Sub[i], name, to)
+			}
+		case isKey(tree, token.ItemMatch):
+			// the pattern binding it and its body
+			for i := 1; i+1 < len(tree.Sub); i += 2 {
+				for _, v := range patternVars(tree.Sub[i]) {
+					if v == b {
+						renameRefs(tree.Sub[i], name, to)
+						renameRefs(tree.Sub[i+1], name, to)
+					}
+				}
+			}
+		default:
+			for i := 0; i < len(tree.Sub); i++ {
+				renameRefs(tree.Sub[i], name, to)
+			}
+		}
+	}
+}
+
+// renameRefs renames the references to name in tree that the caller of
+// a macro wrote, outside of forms binding name again.
+func renameRefs(tree *ast.Tree, name, to string) {
+	walk(tree, func(t *ast.Tree) bool {
+		if t.Val == nil {
+			return true
+		}
+		if t != tree {
+			for _, b := range binders(t) {
+				if b.Val.Var == name && !isKey(t, token.ItemAssign) {
+					return false
+				}
+			}
+		}
+		if t.Val.Mark != introduced && t.Val.Var == name && (t.Val.Typ == ast.ItemVar || isKey(t, token.ItemLambda)) {
+			t.Val.Var = to
+		}
+		return true
+	})
+}
+
+// setMarks marks every node in tree with m.
+func setMarks(tree *ast.Tree, m int) {
+	walk(tree, func(t *ast.Tree) bool {
+		if t.Val != nil {
+			t.Val.Mark = m
+		}
+		return true
+	})
+}
+
+// rename keeps a macro expansion hygienic: names the macro introduced
+// in binding positions, and its references to them, get fresh names so
+// that they can neither capture nor be captured by the names the caller
+// wrote, which carry the mark m.
+func rename(code *ast.Tree, m int) {
+	names := make(map[string]string)
+	walk(code, func(t *ast.Tree) bool {
+		for _, b := range binders(t) {
+			if _, ok := names[b.Val.Var]; !ok && b.Val.Mark != m {
+				names[b.Val.Var] = fresh(b.Val.Var)
+			}
+		}
+		return true
+	})
+	walk(code, func(t *ast.Tree) bool {
+		if t.Val != nil && t.Val.Mark != m && (t.Val.Typ == ast.ItemVar || isKey(t, token.ItemLambda)) {
+			if n, ok := names[t.Val.Var]; ok {
+				t.Val.Var = n
+			}
+		}
+		return true
+	})
+}
+
+// binders returns the nodes naming the variables a form binds.
+func binders(tree *ast.Tree) []*ast.Tree {
+	switch {
+	case isKey(tree, token.ItemAssign) && len(tree.Sub) > 0 && tree.Sub[0].Val.Typ == ast.ItemVar:
+		return tree.Sub[:1]
+	case isKey(tree, token.ItemFunction) && len(tree.Sub) > 0:
+		return paramNames(tree.Sub[0])
+	case isLet(tree) && len(tree.Sub) > 0:
+		return tree.Sub[0].Sub
+	case isKey(tree, token.ItemMatch):
+		var vars []*ast.Tree
+		for i := 1; i < len(tree.Sub); i += 2 {
+			vars = append(vars, patternVars(tree.Sub[i])...)
+		}
+		return vars
+	}
+	return nil
+}
+
+// evalGensym returns a symbol no other symbol is equal to, named after
+// its optional argument.
+func evalGensym(scope *Scope, args []*ast.Tree) *ast.Tree {
+	name := "g"
+	switch {
+	case len(args) > 1:
+		scope.errorf("gensym takes at most 1 argument")
+		return nil
+	case len(args) == 1 && args[0].Val.Typ == ast.ItemSym:
+		name = args[0].Val.Var
+	case len(args) == 1 && args[0].Val.Typ == ast.ItemString:
+		name = args[0].Val.Str
+	}
+	return &ast.Tree{Val: &ast.Node{Typ: ast.ItemSym, Var: fresh(name)}}
+}
+
+// expand expands the macro calls in tree.
+func (e *Expander) expand(tree *ast.Tree) *ast.Tree {
+	switch {
+	case isKey(tree, token.ItemQuote):
+		return tree
+	case isKey(tree, token.ItemQuasiquote):
+		e.expandUnquoted(tree)
+		return tree
+	case isKey(tree, token.ItemMacroExpand):
+		if len(tree.Sub) == 1 && isKey(tree.Sub[0], token.ItemQuote) && len(tree.Sub[0].Sub) == 1 {
+			form := e.MacroExpand(e.toCode(tree.Sub[0].Sub[0]))
+			tree.Sub[0].Sub[0] = e.toData(form)
+			return tree.Sub[0]
+		}
+		return tree
+	}
+	if e.macro(tree) != nil {
+		t := e.MacroExpand(tree)
+		if t != tree {
+			return e.expand(t)
+		}
+	}
+	for i := 0; i < len(tree.Sub); i++ {
+		tree.Sub[i] = e.expand(tree.Sub[i])
+	}
+	unshadow(tree)
+	return tree
+}
+
+// expandUnquoted expands the code unquoted in quasiquoted data.
+func (e *Expander) expandUnquoted(tree *ast.Tree) {
+	for i := 0; i < len(tree.Sub); i++ {
+		t := tree.Sub[i]
+		if isKey(t, token.ItemUnquote) || isKey(t, token.ItemUnquoteSplicing) {
+			for j := 0; j < len(t.Sub); j++ {
+				t.Sub[j] = e.expand(t.Sub[j])
+			}
+		} else {
+			e.expandUnquoted(t)
+		}
+	}
+}
+
+// toData returns code as the data a macro sees: calls become lists
+// headed by the symbol naming the keyword or lambda called, or by the
+// computed head, variables become symbols and vector and map literals
+// become values. Quoted forms are left as they are.
+func (e *Expander) toData(tree *ast.Tree) *ast.Tree {
+	switch {
+	case tree.Val.Typ == ast.ItemVar:
+		return &ast.Tree{Val: &ast.Node{Typ: ast.ItemSym, Var: tree.Val.Var, Mark: tree.Val.Mark}}
+	case isKey(tree, token.ItemSubAsOp):
+		var elems []*ast.Tree
+		for i := 0; i < len(tree.Sub); i++ {
+			elems = append(elems, e.toData(tree.Sub[i]))
+		}
+		return newList(elems)
+	case tree.Val.Typ == ast.ItemKey && !token.Quote(tree.Val.Key):
+		name := tree.Val.Var
+		if name == "" {
+			name = token.StringLookup(tree.Val.Key)
+		}
+		elems := []*ast.Tree{{Val: &ast.Node{Typ: ast.ItemSym, Var: name}}}
+		for i := 0; i < len(tree.Sub); i++ {
+			elems = append(elems, e.toData(tree.Sub[i]))
+		}
+		return newList(elems)
+	case isLiteral(tree):
+		t := &ast.Tree{Val: &ast.Node{Typ: tree.Val.Typ}}
+		for i := 0; i < len(tree.Sub); i++ {
+			t.Sub = append(t.Sub, e.toData(tree.Sub[i]))
+		}
+		return e.scope.collect(t)
+	}
+	return ast.CopyTree(tree, new(ast.Tree))
+}
+
+// defineType records the name of the type a top level defrecord or
+// deftype tree defines, for the annotations after it.
+func (e *Expander) defineType(tree *ast.Tree) {
+	if len(tree.Sub) > 0 && tree.Sub[0].Val.Typ == ast.ItemVar {
+		e.types[tree.Sub[0].Val.Var] = true
+	}
+}
+
+// isType reports whether name names a type annotations can use.
+func (e *Expander) isType(name string) bool {
+	return ast.TypeNames[name] || e.types[name]
+}
+
+// toCode is the inverse of toData: lists headed by a symbol or a list
+// become calls, symbols become variables and vectors and maps become
+// literals. Other lists stay list values. Lambdas and assigns are
+// sequenced and annotated as the parser does.
+func (e *Expander) toCode(tree *ast.Tree) *ast.Tree {
+	switch {
+	case tree.Val.Typ == ast.ItemSym:
+		return &ast.Tree{Val: &ast.Node{Typ: ast.ItemVar, Var: tree.Val.Var, Mark: tree.Val.Mark}}
+	case isList(tree) && len(tree.Sub) > 0 && tree.Sub[0].Val.Typ == ast.ItemSym:
+		name := tree.Sub[0].Val.Var
+		node := &ast.Node{Typ: ast.ItemKey, Key: token.ItemLambda, Var: name, Mark: tree.Sub[0].Val.Mark}
+		if token.IsKeyword(name) {
+			node.Key = token.Lookup(name)
+		}
+		t := &ast.Tree{Val: node}
+		for i := 1; i < len(tree.Sub); i++ {
+			t.Sub = append(t.Sub, e.toCode(tree.Sub[i]))
+		}
+		if node.Key == token.ItemFunction {
+			ast.Sequence(t)
+		}
+		if err := ast.Annotate(t, e.isType); err != nil {
+			e.scope.errorf("%s", err)
+		}
+		return t
+	case isList(tree) && len(tree.Sub) > 0 && isList(tree.Sub[0]):
+		// a computed head
+		t := &ast.Tree{Val: &ast.Node{Typ: ast.ItemKey, Key: token.ItemSubAsOp}}
+		for i := 0; i < len(tree.Sub); i++ {
+			t.Sub = append(t.Sub, e.toCode(tree.Sub[i]))
+		}
+		return t
+	case isVector(tree):
+		t := &ast.Tree{Val: &ast.Node{Typ: ast.ItemVector}}
+		for _, el := range elems(tree) {
+			t.Sub = append(t.Sub, e.toCode(el))
+		}
+		return t
+	case isMap(tree):
+		t := &ast.Tree{Val: &ast.Node{Typ: ast.ItemMap}}
+		for _, en := range entries(tree) {
+			t.Sub = append(t.Sub, e.toCode(en.Key), e.toCode(en.Val))
+		}
+		return t
+	}
+	return tree
+}
@@ -0,0 +1,40 @@
+package optim
+
+import (
+	"testing"
+
+	"github.com/cptaffe/lang/parser"
+)
+
+func TestMacroHygiene(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"introduced parameter", "(macro myor (list a b) `((lambda (list tmp) (cmp tmp tmp ,b)) ,a)) (: tmp 7) (myor 0 tmp)", "7"},
+		{"introduced let", "(macro with1 (list body) `(let (list (x 1)) (+ x ,body))) (: x 10) (with1 x)", "11"},
+		{"parameter shadowing a template name", "(: helper (lambda (list x) (* x 2))) (macro twice (list y) `(helper ,y)) (: f (lambda (list helper) (twice helper))) (f 3)", "6"},
+		{"let shadowing a template name", "(: k 5) (macro addk (list y) `(+ k ,y)) (let (list (k 1)) (addk k))", "6"},
+		{"nested introduced parameters", "(macro myor (list a b) `((lambda (list tmp) (cmp tmp tmp ,b)) ,a)) (macro myor3 (list a b c) `(myor ,a (myor ,b ,c))) (: tmp 7) (myor3 0 0 tmp)", "7"},
+		{"nested template names", "(: helper (lambda (list x) (* x 2))) (macro twice (list y) `(helper ,y)) (macro quad (list y) `(twice (twice ,y))) (: f (lambda (list helper) (quad helper))) (f 3)", "12"},
+		{"a parameter the caller passes in", "(macro bind (list name val body) `((lambda (list ,name) ,body) ,val)) (bind y 4 (* y y))", "16"},
+	}
+	for _, test := range tests {
+		if got := last(test.in); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+// TestGensym checks that a lambda calling gensym gives a new symbol
+// each time, not one computed once when it is assigned or memoized.
+func TestGensym(t *testing.T) {
+	const in = "(: g (lambda (list x) (gensym))) (= (g 1) (g 1))"
+	for _, opts := range []Options{{}, {Memo: true}} {
+		tree, err := Run(parser.Parse(in, "test"), opts)
+		if err != nil {
+			t.Errorf("%s: %s", in, err)
+		} else if got := tree.Sub[1].String(); got != "0" {
+			t.Errorf("%s with %+v = %s, want 0", in, opts, got)
+		}
+	}
+}
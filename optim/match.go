@@ -0,0 +1,465 @@
+package optim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+	"github.com/cptaffe/lang/variable"
+)
+
+// Patterns are written like the code building the values they match:
+// numbers, strings, atoms and quoted data match equal values, _ matches
+// anything, a variable matches anything and is bound to it, and
+// (list p...) matches a list whose elements match p..., where a last
+// &rest (or .) name is bound to the remaining elements. [p...] matches
+// a vector of as many elements matching p..., and (name p...), written
+// like a constructor call, matches a record or variant of type name
+// whose fields match p....
+
+// evalMatch evaluates (match value pattern body pattern body ...): the
+// body after the first pattern value matches is evaluated with the
+// variables of the pattern bound in a child scope.
+func (scope *Scope) evalMatch(tree *ast.Tree) *ast.Tree {
+	if len(tree.Sub) < 3 || len(tree.Sub)%2 == 0 {
+		scope.errorf("incorrect match syntax %s", tree)
+		return nil
+	}
+	v := scope.force(tree.Sub[0])
+	if v == nil {
+		// a string, say, is already its value
+		v = tree.Sub[0]
+	}
+	if !isValue(v) {
+		// not known yet
+		tree.Sub[0] = v
+		return nil
+	}
+	for i := 1; i < len(tree.Sub); i += 2 {
+		if !scope.checkPattern(tree.Sub[i]) {
+			return nil
+		}
+		binds, ok := match(tree.Sub[i], v, nil)
+		if !ok {
+			continue
+		}
+		sc := scope.childScope()
+		for _, b := range binds {
+			sc.Add(b)
+		}
+		// like a let, the body only leaves the match as a value
+		if t := sc.value(ast.CopyTree(tree.Sub[i+1], new(ast.Tree))); isValue(t) {
+			return t
+		}
+		return nil
+	}
+	scope.errorf("match: no pattern matches %s", v)
+	return nil
+}
+
+// checkPattern reports whether tree is a pattern, reporting an error if
+// it isn't.
+func (scope *Scope) checkPattern(tree *ast.Tree) bool {
+	switch {
+	case tree.Val.Typ == ast.ItemVar && !isRestMarker(tree):
+	case tree.Val.Typ == ast.ItemNum || tree.Val.Typ == ast.ItemString || tree.Val.Typ == ast.ItemAtom:
+	case isKey(tree, token.ItemQuote) && len(tree.Sub) == 1:
+	case isKey(tree, token.ItemList):
+		elems := tree.Sub
+		if n := len(elems); n > 1 && isRestMarker(elems[n-2]) {
+			if elems[n-1].Val.Typ != ast.ItemVar || isRestMarker(elems[n-1]) {
+				scope.errorf("%s must be followed by a name in %s", elems[n-2].Val.Var, tree)
+				return false
+			}
+			elems = elems[:n-2]
+		}
+		for _, e := range elems {
+			if !scope.checkPattern(e) {
+				return false
+			}
+		}
+	case isLiteral(tree) && tree.Val.Typ == ast.ItemVector, isKey(tree, token.ItemLambda):
+		for _, e := range tree.Sub {
+			if !scope.checkPattern(e) {
+				return false
+			}
+		}
+	default:
+		scope.errorf("incorrect pattern %s", tree)
+		return false
+	}
+	return true
+}
+
+// match matches value against pattern, returning binds with the
+// variables the pattern binds added.
+func match(pattern, value *ast.Tree, binds []*variable.Var) ([]*variable.Var, bool) {
+	switch {
+	case pattern.Val.Typ == ast.ItemVar && pattern.Val.Var == "_":
+		return binds, true
+	case pattern.Val.Typ == ast.ItemVar:
+		return append(binds, &variable.Var{Var: pattern.Val.Var, Tree: value}), true
+	case isKey(pattern, token.ItemQuote):
+		return binds, equal(pattern.Sub[0], value)
+	case isKey(pattern, token.ItemList):
+		if !isList(value) {
+			return binds, false
+		}
+		elems, rest := pattern.Sub, ""
+		if n := len(elems); n > 1 && isRestMarker(elems[n-2]) {
+			elems, rest = elems[:n-2], elems[n-1].Val.Var
+		}
+		if len(value.Sub) < len(elems) || rest == "" && len(value.Sub) != len(elems) {
+			return binds, false
+		}
+		for i, e := range elems {
+			var ok bool
+			if binds, ok = match(e, value.Sub[i], binds); !ok {
+				return binds, false
+			}
+		}
+		if rest != "" && rest != "_" {
+			binds = append(binds, &variable.Var{Var: rest, Tree: newList(value.Sub[len(elems):])})
+		}
+		return binds, true
+	case isLiteral(pattern) && pattern.Val.Typ == ast.ItemVector:
+		if !isVector(value) || value.Val.Vec.Len() != len(pattern.Sub) {
+			return binds, false
+		}
+		for i, e := range pattern.Sub {
+			var ok bool
+			if binds, ok = match(e, value.Val.Vec.Get(i).(*ast.Tree), binds); !ok {
+				return binds, false
+			}
+		}
+		return binds, true
+	case isKey(pattern, token.ItemLambda):
+		if !isRecord(value) || value.Val.Rec.Type.Name != pattern.Val.Var || len(value.Val.Rec.Vals) != len(pattern.Sub) {
+			return binds, false
+		}
+		for i, e := range pattern.Sub {
+			var ok bool
+			if binds, ok = match(e, value.Val.Rec.Vals[i], binds); !ok {
+				return binds, false
+			}
+		}
+		return binds, true
+	}
+	return binds, equal(pattern, value)
+}
+
+// equal reports whether two values are equal.
+func equal(a, b *ast.Tree) bool {
+	if a.Val.Typ != b.Val.Typ || len(a.Sub) != len(b.Sub) {
+		return false
+	}
+	switch a.Val.Typ {
+	case ast.ItemNum:
+		return a.Val.Num == b.Val.Num
+	case ast.ItemString:
+		return a.Val.Str == b.Val.Str
+	case ast.ItemSym:
+		return a.Val.Var == b.Val.Var
+	case ast.ItemAtom:
+		return a.Val.Atom == b.Val.Atom
+	case ast.ItemRecord:
+		ra, rb := a.Val.Rec, b.Val.Rec
+		if ra.Type != rb.Type || ra.Sum != rb.Sum || len(ra.Fields) != len(rb.Fields) {
+			return false
+		}
+		for i, f := range ra.Fields {
+			v, ok := rb.Field(f)
+			if !ok || !equal(ra.Vals[i], v) {
+				return false
+			}
+		}
+		return true
+	case ast.ItemList:
+		for i := 0; i < len(a.Sub); i++ {
+			if !equal(a.Sub[i], b.Sub[i]) {
+				return false
+			}
+		}
+		return true
+	case ast.ItemVector:
+		if !isVector(a) || !isVector(b) || a.Val.Vec.Len() != b.Val.Vec.Len() {
+			return false
+		}
+		for i := 0; i < a.Val.Vec.Len(); i++ {
+			if !equal(a.Val.Vec.Get(i).(*ast.Tree), b.Val.Vec.Get(i).(*ast.Tree)) {
+				return false
+			}
+		}
+		return true
+	case ast.ItemMap:
+		if !isMap(a) || !isMap(b) || a.Val.Map.Len() != b.Val.Map.Len() {
+			return false
+		}
+		for _, e := range entries(a) {
+			v, ok := b.Val.Map.Get(e.Key.String())
+			if !ok || !equal(e.Val, v.(*ast.Entry).Val) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// patternVars returns the variable nodes a pattern binds.
+func patternVars(pattern *ast.Tree) []*ast.Tree {
+	var vars []*ast.Tree
+	walk(pattern, func(t *ast.Tree) bool {
+		if t.Val.Typ == ast.ItemVar && t.Val.Var != "_" && !isRestMarker(t) {
+			vars = append(vars, t)
+		}
+		return !isKey(t, token.ItemQuote)
+	})
+	return vars
+}
+
+// irrefutable reports whether pattern matches every value.
+func irrefutable(pattern *ast.Tree) bool {
+	return pattern.Val.Typ == ast.ItemVar && !isRestMarker(pattern)
+}
+
+// CheckMatches warns about every match in tree that some value matches
+// no pattern of, and about patterns that can never be reached. Matches
+// on the variants of a sum type declared in tree must match each of
+// them.
+func CheckMatches(tree *ast.Tree) []string {
+	var warnings []string
+	types := declared(tree)
+	walk(tree, func(t *ast.Tree) bool {
+		if isKey(t, token.ItemQuote) {
+			return false
+		}
+		if !isKey(t, token.ItemMatch) || len(t.Sub) < 3 || len(t.Sub)%2 == 0 {
+			return true
+		}
+		var patterns []*ast.Tree
+		for i := 1; i < len(t.Sub); i += 2 {
+			p := t.Sub[i]
+			if len(patterns) > 0 && irrefutable(patterns[len(patterns)-1]) {
+				warnings = append(warnings, fmt.Sprintf("match on %s: pattern %s is unreachable", t.Sub[0], p))
+			}
+			patterns = append(patterns, p)
+		}
+		if m := missing(patterns, types); m != "" {
+			warnings = append(warnings, fmt.Sprintf("match on %s is not exhaustive: %s matches no pattern", t.Sub[0], m))
+		}
+		return true
+	})
+	return warnings
+}
+
+// missing describes a value no pattern matches, or returns "" if the
+// patterns match every value. Patterns that are all list patterns are
+// taken to match on a list, so they only need to cover every length,
+// and patterns that are all variants of one sum type are taken to match
+// on that type, so they only need to cover every variant.
+func missing(patterns []*ast.Tree, types map[string]*sumType) string {
+	max, lists, others := 0, false, false
+	nums := make(map[float64]bool)
+	if sum := types[sumOf(patterns, types)]; sum != nil {
+		for _, v := range sum.variants {
+			if !coversVariant(patterns, v) {
+				return v.String()
+			}
+		}
+		return ""
+	}
+	for _, p := range patterns {
+		switch {
+		case irrefutable(p):
+			return ""
+		case isKey(p, token.ItemList):
+			lists = true
+			if len(p.Sub) > max {
+				max = len(p.Sub)
+			}
+		case p.Val.Typ == ast.ItemNum:
+			nums[p.Val.Num] = true
+			fallthrough
+		default:
+			others = true
+		}
+	}
+	if !lists {
+		if len(nums) == 0 {
+			return "any other value"
+		}
+		n := 0.0
+		for nums[n] {
+			n++
+		}
+		return strconv.FormatFloat(n, 'g', -1, 64)
+	}
+	// longer lists are only matched by &rest patterns, like max+1
+	for n := 0; n <= max+1; n++ {
+		switch {
+		case coversLen(patterns, n):
+		case n == 1:
+			return "a list of 1 element"
+		default:
+			return fmt.Sprintf("a list of %d elements", n)
+		}
+	}
+	if others {
+		return "a value that isn't a list"
+	}
+	return ""
+}
+
+// coversLen reports whether one of the patterns matches every list of
+// n elements.
+func coversLen(patterns []*ast.Tree, n int) bool {
+	for _, p := range patterns {
+		if !isKey(p, token.ItemList) {
+			continue
+		}
+		elems, rest := p.Sub, false
+		if k := len(elems); k > 1 && isRestMarker(elems[k-2]) {
+			elems, rest = elems[:k-2], true
+		}
+		all := len(elems) == n || rest && n >= len(elems)
+		for _, e := range elems {
+			all = all && irrefutable(e)
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// sumType is a declared sum type, or record type, which is a sum type
+// of one variant of its own name.
+type sumType struct {
+	name     string
+	variants []*variantDecl
+}
+
+// variantDecl is a declared variant and its fields.
+type variantDecl struct {
+	name   string
+	fields []string
+}
+
+// String writes v like a pattern matching it, e.g. (rect w h).
+func (v *variantDecl) String() string {
+	return "(" + strings.Join(append([]string{v.name}, v.fields...), " ") + ")"
+}
+
+// declared returns the sum and record types tree declares by name,
+// from their deftype and defrecord forms or, once these are expanded,
+// from their constructors.
+func declared(tree *ast.Tree) map[string]*sumType {
+	types := make(map[string]*sumType)
+	add := func(sum, name string, fields []string) {
+		t := types[sum]
+		if t == nil {
+			t = &sumType{name: sum}
+			types[sum] = t
+		}
+		for _, v := range t.variants {
+			if v.name == name {
+				return
+			}
+		}
+		t.variants = append(t.variants, &variantDecl{name: name, fields: fields})
+	}
+	names := func(ts []*ast.Tree, typ ast.ItemType) ([]string, bool) {
+		var ns []string
+		for _, t := range ts {
+			if t.Val.Typ != typ {
+				return nil, false
+			}
+			if typ == ast.ItemAtom {
+				ns = append(ns, t.Val.Atom.Name)
+			} else {
+				ns = append(ns, t.Val.Var)
+			}
+		}
+		return ns, true
+	}
+	walk(tree, func(t *ast.Tree) bool {
+		switch {
+		case isKey(t, token.ItemQuote):
+			return false
+		case isKey(t, token.ItemDefrecord) && len(t.Sub) == 2 && t.Sub[0].Val.Typ == ast.ItemVar:
+			if fields, ok := names(t.Sub[1].Sub, ast.ItemVar); ok {
+				add(t.Sub[0].Val.Var, t.Sub[0].Val.Var, fields)
+			}
+		case isKey(t, token.ItemDeftype) && len(t.Sub) > 1 && t.Sub[0].Val.Typ == ast.ItemVar:
+			for _, v := range t.Sub[1:] {
+				if fields, ok := names(v.Sub, ast.ItemVar); ok && isKey(v, token.ItemLambda) {
+					add(t.Sub[0].Val.Var, v.Val.Var, fields)
+				}
+			}
+		case isKey(t, token.ItemRecord) && len(t.Sub)%2 == 1:
+			// constructors pass their fields as :field field
+			decl := []*ast.Tree{t.Sub[0]}
+			for i := 1; i < len(t.Sub); i += 2 {
+				decl = append(decl, t.Sub[i])
+			}
+			if ns, ok := names(decl, ast.ItemAtom); ok {
+				add(ns[0], ns[0], ns[1:])
+			}
+		case isKey(t, token.ItemVariant) && len(t.Sub) > 0 && len(t.Sub)%2 == 0:
+			decl := []*ast.Tree{t.Sub[0], t.Sub[1]}
+			for i := 2; i < len(t.Sub); i += 2 {
+				decl = append(decl, t.Sub[i])
+			}
+			if ns, ok := names(decl, ast.ItemAtom); ok {
+				add(ns[0], ns[1], ns[2:])
+			}
+		}
+		return true
+	})
+	return types
+}
+
+// sumOf returns the name of the sum type every pattern is a variant of,
+// or "" if they aren't all variants of one.
+func sumOf(patterns []*ast.Tree, types map[string]*sumType) string {
+	sum := ""
+	for _, p := range patterns {
+		if !isKey(p, token.ItemLambda) {
+			return ""
+		}
+		s := ""
+		for _, t := range types {
+			for _, v := range t.variants {
+				if v.name == p.Val.Var {
+					s = t.name
+				}
+			}
+		}
+		if s == "" || sum != "" && s != sum {
+			return ""
+		}
+		sum = s
+	}
+	return sum
+}
+
+// coversVariant reports whether one of the patterns matches every value
+// of the variant v.
+func coversVariant(patterns []*ast.Tree, v *variantDecl) bool {
+	for _, p := range patterns {
+		if p.Val.Var != v.name || len(p.Sub) != len(v.fields) {
+			continue
+		}
+		all := true
+		for _, e := range p.Sub {
+			all = all && irrefutable(e)
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package optim
+
+import "testing"
+
+func TestEqualRecords(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"(deftype shape (circle r)) (= (circle 1) (circle 1))", "1"},
+		{"(deftype shape (circle r)) (= (circle 1) (circle 2))", "0"},
+		// a variant isn't a record of the same name
+		{"(deftype shape (circle r)) (= (circle 1) (record :circle :r 1))", "0"},
+		{"(defrecord circle (list r)) (= (circle 1) (record :circle :r 1))", "1"},
+		{"(deftype shape (circle r)) (match (circle 1) (circle r) r _ 0)", "1"},
+	}
+	for _, test := range tests {
+		if got := last(test.in); got != test.want {
+			t.Errorf("%s = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestAtoms(t *testing.T) {
+	runAll(t, Options{}, []runTest{
+		// an atom evaluates to itself
+		{in: "(begin :red)", want: ":red"},
+		{in: "(: c :red) (list c :green)", want: "list{:red, :green}"},
+		// atoms with the same name are one interned atom
+		{in: "(= :red :red)", want: "1"},
+		{in: "(: c :red) (= c :red)", want: "1"},
+		{in: "(= :red :green)", want: "0"},
+		{in: `(= :red "red")`, want: "0"},
+		{in: "(= :red 'red)", want: "0"},
+		{in: "(get {:a 1 :b 2} :b)", want: "2"},
+	})
+	a, b := form(t, "(f :red)").Sub[0], form(t, "(g 1 :red)").Sub[1]
+	if a.Val.Atom == nil || a.Val.Atom != b.Val.Atom {
+		t.Errorf(":red parsed as %p and %p, want one atom", a.Val.Atom, b.Val.Atom)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	runAll(t, Options{}, []runTest{
+		// nested list patterns
+		{in: "(match (list 1 (list 2 3)) (list a (list b c)) (+ a (* b c)) _ 0)", want: "7"},
+		{in: "(match (list 1 (list 2)) (list a (list b c)) 1 (list a (list b)) b)", want: "2"},
+		{in: "(match (list 1 2 3) (list a &rest r) r)", want: "list{2, 3}"},
+		{in: "(match [1 [2 3]] [a [b c]] (+ a c))", want: "4"},
+		// literal patterns
+		{in: "(match 3 1 :one 3 :three _ :other)", want: ":three"},
+		{in: `(match "b" "a" 1 "b" 2 _ 3)`, want: "2"},
+		{in: "(match :red :green 1 :red 2 _ 3)", want: "2"},
+		{in: "(match 'x 'y 1 'x 2 _ 3)", want: "2"},
+		// the _ wildcard matches anything and binds nothing
+		{in: "(match 5 1 1 _ 9)", want: "9"},
+		{in: "(match (list 1 2) (list _ b) b)", want: "2"},
+		{in: "(: _ 4) (match 5 _ (+ _ 0))", want: "4"},
+		// the value isn't known yet
+		{in: "(match x 1 2 _ 3)", want: "match{(x), 1, 2, (_), 3}"},
+		// no clause matching
+		{in: "(match 5 1 1)", err: true},
+		{in: "(match (list 1 2) (list a) a)", err: true},
+		// malformed
+		{in: "(match 1 1)", err: true},
+		{in: "(match 1 (+ a) 1)", err: true},
+	})
+}
+
+func TestDestructuring(t *testing.T) {
+	const swap = "(: swap (lambda (list (list a b)) (list b a))) "
+	runAll(t, Options{}, []runTest{
+		{in: swap + "(swap (list 1 2))", want: "list{2, 1}"},
+		{in: "(: f (lambda (list (list a (list b c))) (list a b c))) (f (list 1 (list 2 3)))", want: "list{1, 2, 3}"},
+		{in: "(: f (lambda (list x (list _ &rest r)) (cons x r))) (f 0 (list 1 2 3))", want: "list{0, 2, 3}"},
+		// an argument of the wrong shape
+		{in: swap + "(swap (list 1 2 3))", err: true},
+		{in: swap + "(swap (list 1))", err: true},
+		{in: swap + "(swap 1)", err: true},
+		// and the wrong number of arguments
+		{in: swap + "(swap (list 1 2) (list 3 4))", err: true},
+	})
+}
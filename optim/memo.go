@@ -0,0 +1,210 @@
+package optim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+	"github.com/cptaffe/lang/variable"
+)
+
+// DefaultMemoSize is the number of results memoized when Options
+// doesn't say.
+const DefaultMemoSize = 1024
+
+// memoKey identifies a call of a lambda definition.
+type memoKey struct {
+	def  *ast.Tree
+	env  string // the bindings it reads, see Scope.env
+	args string
+}
+
+// memo caches lambda results, forgetting the oldest once full.
+type memo struct {
+	size  int
+	vals  map[memoKey]*ast.Tree
+	order []memoKey
+}
+
+func newMemo(size int) *memo {
+	return &memo{
+		size: size,
+		vals: make(map[memoKey]*ast.Tree),
+	}
+}
+
+func (m *memo) get(k memoKey) (*ast.Tree, bool) {
+	t, ok := m.vals[k]
+	return t, ok
+}
+
+func (m *memo) put(k memoKey, t *ast.Tree) {
+	if _, ok := m.vals[k]; ok || m.size <= 0 {
+		return
+	}
+	if len(m.order) >= m.size {
+		delete(m.vals, m.order[0])
+		m.order = m.order[1:]
+	}
+	m.vals[k] = t
+	m.order = append(m.order, k)
+}
+
+// forget drops everything cached, for when a variable changes.
+func (s *state) forget() {
+	if len(s.pure) > 0 {
+		s.pure = make(map[memoKey]bool)
+	}
+	if len(s.memo.vals) > 0 {
+		s.memo = newMemo(s.memo.size)
+	}
+}
+
+// memoLambda calls the pure lambda def, which reads the bindings env,
+// returning a cached result if it has been called with the same
+// constant arguments and bindings before.
+func (scope *Scope) memoLambda(name string, def *ast.Tree, env string, args []*ast.Tree) *ast.Tree {
+	sig := scope.params(def.Sub[0])
+	if sig == nil || scope.state.AutoCurry && len(args) < len(sig.required) {
+		return scope.lambda(name, ast.CopyTree(def, new(ast.Tree)), args)
+	}
+	if !sig.check(scope, name, len(args)) {
+		return nil
+	}
+	vals := make([]*ast.Tree, len(args))
+	key := make([]string, len(args))
+	cache := true
+	for i := 0; i < len(args); i++ {
+		vals[i] = scope.value(args[i])
+		// symbolic arguments may still resolve differently
+		switch vals[i].Val.Typ {
+		case ast.ItemNum, ast.ItemString:
+			key[i] = vals[i].String()
+		default:
+			cache = false
+		}
+	}
+	k := memoKey{def: def, env: env, args: strings.Join(key, " ")}
+	if t, ok := scope.state.memo.get(k); ok && cache {
+		return ast.CopyTree(t, new(ast.Tree))
+	}
+	t := scope.apply(name, ast.CopyTree(def, new(ast.Tree)), sig, vals)
+	if t != nil && cache {
+		scope.state.memo.put(k, ast.CopyTree(t, new(ast.Tree)))
+	}
+	return t
+}
+
+// pure reports whether calls of the lambda definition def can be
+// memoized where they are made, and the bindings they read there,
+// caching the answer until a variable changes.
+func (scope *Scope) pure(def *ast.Tree) (string, bool) {
+	env, ok := scope.env(def)
+	if !ok {
+		return "", false
+	}
+	k := memoKey{def: def, env: env}
+	p, ok := scope.state.pure[k]
+	if !ok {
+		p = pure(def, func(name string) *ast.Tree {
+			if v := scope.GetName(name); v != nil {
+				return v.Tree
+			}
+			return nil
+		}, make(map[*ast.Tree]bool))
+		scope.state.pure[k] = p
+	}
+	return env, p
+}
+
+// env describes the variables the lambda def reads, directly or through
+// the lambdas they hold, as scope binds them, so that a call made where
+// a let or a parameter binds one of them differently isn't mistaken for
+// one made where it didn't. It is not ok if one of them isn't bound to
+// a lambda, or if a lambda that is called binds it as a parameter,
+// which dynamic scoping lets the lambdas it calls see instead.
+func (scope *Scope) env(def *ast.Tree) (string, bool) {
+	vars := make(map[string]*variable.Var)
+	bound := make(map[string]bool)
+	seen := make(map[*ast.Tree]bool)
+	var visit func(fn *ast.Tree) bool
+	visit = func(fn *ast.Tree) bool {
+		if seen[fn] {
+			return true
+		}
+		seen[fn] = true
+		if !isKey(fn, token.ItemFunction) || len(fn.Sub) != 2 {
+			return false
+		}
+		for _, p := range paramNames(fn.Sub[0]) {
+			bound[p.Val.Var] = true
+		}
+		for name := range freeVars(fn) {
+			if name == "self" {
+				continue
+			}
+			v := scope.GetName(name)
+			if v == nil || v.Tree == nil || !visit(v.Tree) {
+				return false
+			}
+			vars[name] = v
+		}
+		return true
+	}
+	if !visit(def) {
+		return "", false
+	}
+	env := make([]string, 0, len(vars))
+	for name, v := range vars {
+		if bound[name] {
+			return "", false
+		}
+		env = append(env, fmt.Sprintf("%s=%p", name, v))
+	}
+	sort.Strings(env)
+	return strings.Join(env, " "), true
+}
+
+// Pure reports, for every lambda assigned once at the top level of a
+// program, whether it is pure: it has no side effects and its result
+// only depends on its arguments.
+func Pure(tree *ast.Tree) map[string]bool {
+	assigns := countAssigns(tree)
+	defs := make(map[string]*ast.Tree)
+	for i := 0; i < len(tree.Sub); i++ {
+		if name, fn := lambdaAssign(tree.Sub[i]); fn != nil && assigns[name] == 1 {
+			defs[name] = fn
+		}
+	}
+	p := make(map[string]bool)
+	for name, fn := range defs {
+		p[name] = pure(fn, func(name string) *ast.Tree {
+			return defs[name]
+		}, make(map[*ast.Tree]bool))
+	}
+	return p
+}
+
+// pure reports whether fn is a pure lambda, looking up the lambdas it
+// calls by name. Lambdas in seen that are still being checked are
+// assumed pure, which is what makes recursive lambdas pure.
+func pure(fn *ast.Tree, lookup func(string) *ast.Tree, seen map[*ast.Tree]bool) bool {
+	if p, ok := seen[fn]; ok {
+		return p
+	}
+	seen[fn] = true
+	p := isKey(fn, token.ItemFunction) && len(fn.Sub) == 2 && !sideEffects(fn.Sub[1])
+	for name := range freeVars(fn) {
+		if !p {
+			break
+		}
+		if name != "self" {
+			g := lookup(name)
+			p = g != nil && pure(g, lookup, seen)
+		}
+	}
+	seen[fn] = p
+	return p
+}
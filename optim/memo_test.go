@@ -0,0 +1,57 @@
+package optim
+
+import (
+	"testing"
+
+	"github.com/cptaffe/lang/parser"
+)
+
+func TestMemoDynamicScope(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`(: fib (lambda (list n) (cmp (< n 2) n (+ (fib (- n 1)) (fib (- n 2))))))
+		  (fib 15)`, "610"},
+		// k is rebound by a let
+		{`(: k (lambda (list) 1))
+		  (: f (lambda (list x) (+ x (k))))
+		  (f 1)
+		  (let (list (k (lambda (list) 50))) (f 1))`, "51"},
+		// and by a parameter
+		{`(: k (lambda (list) 1))
+		  (: f (lambda (list x) (+ x (k))))
+		  (: g (lambda (list k) (f 1)))
+		  (f 1)
+		  (g (lambda (list) 100))`, "101"},
+		// x is read from the lambda calling h
+		{`(: h (lambda (list) x))
+		  (: f (lambda (list x) (h)))
+		  (f 1)
+		  (f 2)`, "2"},
+	}
+	for _, test := range tests {
+		for _, memo := range []bool{false, true} {
+			tree := EvalWith(parser.Parse(test.in, "test"), Options{Memo: memo})
+			if got := tree.Sub[len(tree.Sub)-1].String(); got != test.want {
+				t.Errorf("%s = %s with Memo %v, want %s", test.in, got, memo, test.want)
+			}
+		}
+	}
+}
+
+func TestMemoPut(t *testing.T) {
+	m := newMemo(2)
+	a, b := memoKey{args: "a"}, memoKey{args: "b"}
+	m.put(a, num(1))
+	m.put(a, num(1))
+	m.put(b, num(2))
+	if len(m.order) != 2 {
+		t.Fatalf("order = %v after putting a twice and b, want [a b]", m.order)
+	}
+	m.put(memoKey{args: "c"}, num(3))
+	for k, want := range map[memoKey]bool{a: false, b: true} {
+		if _, ok := m.get(k); ok != want {
+			t.Errorf("get(%v) found %v, want %v", k, ok, want)
+		}
+	}
+}
@@ -5,10 +5,33 @@ import (
 	//"github.com/cptaffe/lang/parser"
 	"github.com/cptaffe/lang/token"
 	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/types"
 	"github.com/cptaffe/lang/variable"
 )
 
-type Scope variable.Scope
+// Scope is a variable scope of one evaluation.
+type Scope struct {
+	variable.Scope
+	state *state // shared by every scope of the evaluation
+}
+
+// Options change how a tree is evaluated.
+type Options struct {
+	Memo        bool // cache the results of pure lambdas by their arguments
+	MemoSize    int  // most results cached, DefaultMemoSize if 0
+	AutoCurry   bool // calls with too few arguments give partial functions, not errors
+	NoContracts bool // skip assertions and the pre and post conditions of lambdas
+	Typecheck   bool // infer the types of the program first, not evaluating it if they don't agree
+}
+
+// state is shared by every scope of one evaluation
+type state struct {
+	Options
+	memo *memo
+	pure map[memoKey]bool // purity of lambda definitions in an env
+	throwing int // errors are thrown, not printed, when > 0
+	pos  ast.Pos // of the form being evaluated
+}
 
 // error printing
 func errorf(format string, args ...interface{}) {
@@ -17,17 +40,106 @@ func errorf(format string, args ...interface{}) {
 	fmt.Printf("\033[1m%s: \033[31merror:\033[0m\033[1m %s\033[0m\n", "optim", msg)
 }
 
+// warning printing
+func warnf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Printf("\033[1m%s: \033[33mwarning:\033[0m\033[1m %s\033[0m\n", "optim", msg)
+}
+
 // generate child scope
 func (s *Scope) childScope() *Scope {
-	scope := new(Scope)
-	scope.Parent = (*variable.Scope)(s)
+	scope := &Scope{state: s.state}
+	scope.Parent = &s.Scope
 	return scope
 }
 
 // exported api
 func Eval(tree *ast.Tree) *ast.Tree {
-	scope := new(Scope) // scope
-	return scope.evalChildren(tree) // evaluate in scope
+	return EvalWith(tree, Options{})
+}
+
+// EvalWith expands the macros in tree and evaluates it with the given
+// options. Errors are printed, one thrown and not caught stops the top
+// level form it was thrown in.
+func EvalWith(tree *ast.Tree, opts Options) *ast.Tree {
+	scope := newScope(opts)
+	tree, _ = scope.prepare(tree)
+	if opts.Typecheck {
+		if _, errs := types.Check(tree); len(errs) > 0 {
+			for _, err := range errs {
+				errorf("%s", err)
+			}
+			return tree
+		}
+	}
+	for i := 0; i < len(tree.Sub); i++ {
+		if err := scope.top(tree, i); err != nil {
+			errorf("uncaught %s", err)
+		}
+	}
+	return tree
+}
+
+// Run is like EvalWith, except that every error is thrown, and the
+// first one not caught stops the evaluation and is returned as an
+// *Error with the tree as it was then.
+func Run(tree *ast.Tree, opts Options) (*ast.Tree, error) {
+	scope := newScope(opts)
+	scope.state.throwing++
+	tree, err := scope.prepare(tree)
+	if err != nil {
+		return tree, err
+	}
+	if opts.Typecheck {
+		if _, errs := types.Check(tree); len(errs) > 0 {
+			return tree, errs[0]
+		}
+	}
+	for i := 0; i < len(tree.Sub); i++ {
+		if err := scope.top(tree, i); err != nil {
+			return tree, err
+		}
+	}
+	return tree, nil
+}
+
+// prepare expands the macros in tree and prints the warnings about its
+// matches. Errors in expanding are raised as they are in scope,
+// returning the one thrown.
+func (scope *Scope) prepare(tree *ast.Tree) (t *ast.Tree, err error) {
+	t = tree
+	defer uncaught(&err)
+	e := NewExpander()
+	e.scope.state.throwing = scope.state.throwing
+	t = e.Expand(tree)
+	for _, w := range CheckMatches(t) {
+		warnf("%s", w)
+	}
+	return t, nil
+}
+
+// top evaluates the i'th top level form of tree, returning what it
+// threw.
+func (scope *Scope) top(tree *ast.Tree, i int) (err error) {
+	defer uncaught(&err)
+	if t := scope.eval(tree.Sub[i]); t != nil {
+		tree.Sub[i] = t
+	}
+	return nil
+}
+
+// newScope returns the top scope of an evaluation
+func newScope(opts Options) *Scope {
+	if opts.MemoSize == 0 {
+		opts.MemoSize = DefaultMemoSize
+	}
+	return &Scope{
+		state: &state{
+			Options: opts,
+			memo:    newMemo(opts.MemoSize),
+			pure:    make(map[memoKey]bool),
+		},
+	}
 }
 
 // evaluates children
@@ -48,8 +160,10 @@ func (scope *Scope) eval(tree *ast.Tree) *ast.Tree {
 		return scope.evalKey(tree)
 	} else if tree.Val.Typ == ast.ItemVar {
 		return scope.evalVar(tree)
-	} else if tree.Val.Typ == ast.ItemNum{
+	} else if tree.Val.Typ == ast.ItemNum || tree.Val.Typ == ast.ItemList || tree.Val.Typ == ast.ItemSym || tree.Val.Typ == ast.ItemAtom || tree.Val.Typ == ast.ItemRecord {
 		return tree
+	} else if tree.Val.Typ == ast.ItemVector || tree.Val.Typ == ast.ItemMap {
+		return scope.evalLiteral(tree)
 	} else {
 		return nil
 	}
@@ -57,21 +171,82 @@ func (scope *Scope) eval(tree *ast.Tree) *ast.Tree {
 
 // evaluates keys
 func (scope *Scope) evalKey(tree *ast.Tree) *ast.Tree {
+	if tree.Val.Pos.Line > 0 {
+		defer scope.at(tree.Val.Pos)()
+	}
 	if tree.Val.Key == token.ItemAssign {
 		return scope.evalAssign(tree)
 	} else if tree.Val.Key == token.ItemFunction {
 		return scope.evalFunc(tree)
 	} else if tree.Val.Key == token.ItemLambda {
 		return scope.evalLambda(tree)
+	} else if tree.Val.Key == token.ItemSubAsOp {
+		return scope.evalSubAsOp(tree)
 	} else if tree.Val.Key == token.ItemCmp {
 		return scope.evalCmp(tree)
+	} else if tree.Val.Key == token.ItemQuote {
+		return scope.evalQuote(tree)
+	} else if tree.Val.Key == token.ItemQuasiquote {
+		return scope.evalQuasiquote(tree)
+	} else if tree.Val.Key == token.ItemUnquote || tree.Val.Key == token.ItemUnquoteSplicing {
+		scope.errorf("unquote outside quasiquote")
+		return nil
+	} else if tree.Val.Key == token.ItemBegin {
+		return scope.evalBegin(tree)
+	} else if tree.Val.Key == token.ItemTry {
+		return scope.evalTry(tree)
+	} else if tree.Val.Key == token.ItemCatch || tree.Val.Key == token.ItemFinally {
+		scope.errorf("%s outside try", tree.Val.Var)
+		return nil
+	} else if tree.Val.Key == token.ItemAssert {
+		return scope.evalAssert(tree)
+	} else if tree.Val.Key == token.ItemPre || tree.Val.Key == token.ItemPost {
+		scope.errorf("%s outside the start of a lambda body", tree.Val.Var)
+		return nil
+	} else if tree.Val.Key == token.ItemMatch {
+		return scope.evalMatch(tree)
+	} else if isLet(tree) {
+		return scope.evalLet(tree)
+	} else if tree.Val.Key == token.ItemMacro {
+		scope.errorf("macro defined outside the top level")
+		return nil
+	} else if tree.Val.Key == token.ItemDefrecord || tree.Val.Key == token.ItemDeftype {
+		scope.errorf("type defined outside the top level")
+		return nil
+	} else if tree.Val.Key == token.ItemMacroExpand {
+		scope.errorf("macroexpand takes a quoted form")
+		return nil
+	} else if fn, ok := builtins[tree.Val.Key]; ok {
+		return scope.evalBuiltin(tree, fn)
 	} else {
 		t := scope.evalChildren(tree)
 		if t != nil {
 			val, ok := evalLookup[t.Val.Key]
+			if comparisons[t.Val.Key] && len(t.Sub) != 2 {
+				scope.errorf("%s takes two arguments", token.StringLookup(t.Val.Key))
+				return nil
+			}
 			if ok && onlyNums(t) {
 				return val(t)
 			}
+			if t.Val.Key == token.ItemEq && len(t.Sub) == 2 {
+				// atoms, strings, lists, vectors, maps and records,
+				// which variables may hold as calls making them
+				a, b := scope.value(t.Sub[0]), scope.value(t.Sub[1])
+				if isValue(a) && isValue(b) {
+					return truth(equal(a, b))
+				}
+			}
+			for i := 0; ok && i < len(t.Sub); i++ {
+				if t.Sub[i].Val.Typ == ast.ItemString {
+					scope.errorf("%s of string %s, strings are joined with concat", token.StringLookup(t.Val.Key), t.Sub[i])
+					return nil
+				}
+			}
+			// partially known, simplify what we can
+			if f := Fold(t); f != t {
+				return f
+			}
 		} else {
 			return nil
 		}
@@ -80,9 +255,9 @@ func (scope *Scope) evalKey(tree *ast.Tree) *ast.Tree {
 }
 
 func onlyNums(tree *ast.Tree) bool {
-	num := true
+	num := len(tree.Sub) > 0
 	for i := 0; i < len(tree.Sub); i++ {
-		if tree.Sub[0].Val.Typ != ast.ItemNum {
+		if tree.Sub[i].Val.Typ != ast.ItemNum {
 			num = false
 			break
 		}
@@ -91,7 +266,7 @@ func onlyNums(tree *ast.Tree) bool {
 }
 
 func (scope *Scope) evalVar(tree *ast.Tree) *ast.Tree {
-	t := ((*variable.Scope)(scope)).GetName(tree.Val.Var)
+	t := scope.GetName(tree.Val.Var)
 	if t != nil && t.Tree != nil {
 		return ast.CopyTree(t.Tree, new(ast.Tree))
 	} else {
@@ -102,12 +277,27 @@ func (scope *Scope) evalVar(tree *ast.Tree) *ast.Tree {
 func (scope *Scope) evalAssign(tree *ast.Tree) *ast.Tree {
 	if len(tree.Sub) == 2 && tree.Sub[0].Val.Typ == ast.ItemVar {
 		name := tree.Sub[0].Val.Var
-		assig := ((*variable.Scope)(scope)).GetName(name)
+		assig := scope.GetName(name)
+		if typ, v := tree.Sub[0].Val.Type, tree.Sub[1]; typ != "" && (isValue(v) || isFunction(v)) && !conforms(v, typ) {
+			scope.errorf("%s = %s is not %s", name, v, typ)
+			return nil
+		}
 
-		// pre-optimized lambdas
-		if tree.Sub[1].Val.Key == token.ItemFunction {
-			tree.Sub[1] = (new(Scope)).evalChildren(tree.Sub[1])
+		// pre-optimized lambdas, unless assigns would run early
+		if tree.Sub[1].Val.Key == token.ItemFunction && !sideEffects(tree.Sub[1]) {
+			// not the parameter list, (list ...) makes list values,
+			// and errors aren't thrown as the body may never run
+			st := *scope.state
+			st.throwing = 0
+			fn, pre := tree.Sub[1], &Scope{state: &st}
+			for i := 1; i < len(fn.Sub); i++ {
+				if t := pre.eval(fn.Sub[i]); t != nil {
+					fn.Sub[i] = t
+				}
+			}
 		}
+		// anything cached may depend on the old value
+		scope.state.forget()
 
 		if assig != nil {
 			assig.Tree = tree.Sub[1]
@@ -116,7 +306,7 @@ func (scope *Scope) evalAssign(tree *ast.Tree) *ast.Tree {
 				Var: name,
 				Tree: tree.Sub[1],
 			}
-			scope.Scope = append(scope.Scope, val)
+			scope.Add(val)
 		}
 		// return tree
 			return &ast.Tree{
@@ -127,81 +317,150 @@ func (scope *Scope) evalAssign(tree *ast.Tree) *ast.Tree {
 				},
 			}
 	} else {
-		errorf("incorrect assign syntax %s", tree)
+		scope.errorf("incorrect assign syntax %s", tree)
 		return nil
 	}
 }
 
 // these may not exist, not sure...
 func (scope *Scope) evalFunc(tree *ast.Tree) *ast.Tree {
-	if len(tree.Sub) == 3 {
-		return scope.lambda(tree, tree.Sub[2].Sub)
+	if len(tree.Sub) == 2 {
+		// function value
+		return nil
 	} else {
-		errorf("implicit lambda: arg number incorrect")
+		scope.errorf("lambda takes a parameter list and a body")
 		return nil
 	}
 }
 
 func (scope *Scope) evalLambda(tree *ast.Tree) *ast.Tree {
-	// eval subs
-	def := scope.evalVar(&ast.Tree{
-		Val: &ast.Node{
-			Typ: ast.ItemVar,
-			Var: tree.Val.Var,
-		},
-	})
-	if def != nil {
-		return scope.lambda(def, tree.Sub)
+	def := scope.GetName(tree.Val.Var)
+	if def != nil && def.Tree != nil {
+		fn := def.Tree
+		if !isKey(fn, token.ItemFunction) {
+			// a function value still to be computed, like (curry f)
+			fn = scope.value(ast.CopyTree(fn, new(ast.Tree)))
+			if !isKey(fn, token.ItemFunction) {
+				if isValue(fn) {
+					scope.errorf("%s is not a function", tree.Val.Var)
+				}
+				return nil
+			}
+		} else if scope.state.Memo {
+			if env, ok := scope.pure(fn); ok {
+				return scope.memoLambda(tree.Val.Var, fn, env, tree.Sub)
+			}
+		}
+		return scope.lambda(tree.Val.Var, ast.CopyTree(fn, new(ast.Tree)), tree.Sub)
 	} else {
-		errorf("undefined func")
+		scope.errorf("undefined func")
 		return nil
 	}
 }
 
-// evaluates lambdas
-func (scope *Scope) lambda(tree *ast.Tree, args []*ast.Tree) *ast.Tree {
-	sc := scope.childScope()
-	if len(tree.Sub[0].Sub) >= len(args) {
-		sc.Scope = append(sc.Scope, &variable.Var{
-				Var: "self",
-				Tree: ast.CopyTree(tree, new(ast.Tree)),
-			})
-		//return nil
-		for i := 0; i < len(tree.Sub[0].Sub); i++ {
-			// populate scope
-			sc.Scope = append(sc.Scope, &variable.Var{
-				Var: tree.Sub[0].Sub[i].Val.Var,
-				Tree: scope.eval(args[i]),
-			})
-		}
-		tr := sc.eval(tree.Sub[1])
-		if tr != nil {
-			return tr
-		} else {
-			return tree.Sub[1]
+// evaluates calls whose head is computed, like ((lambda (list x) x) 1)
+func (scope *Scope) evalSubAsOp(tree *ast.Tree) *ast.Tree {
+	if len(tree.Sub) == 0 {
+		scope.errorf("call: nothing to call")
+		return nil
+	}
+	fn := scope.value(tree.Sub[0])
+	if isFunction(fn) {
+		return scope.lambda("function "+fn.String(), ast.CopyTree(fn, new(ast.Tree)), tree.Sub[1:])
+	} else if isValue(fn) {
+		scope.errorf("%s is not a function", fn)
+	} else {
+		// not known yet
+		tree.Sub[0] = fn
+	}
+	return nil
+}
+
+// evaluates lambdas, name is what arity errors call it
+func (scope *Scope) lambda(name string, tree *ast.Tree, args []*ast.Tree) *ast.Tree {
+	sig := scope.params(tree.Sub[0])
+	if sig != nil && scope.state.AutoCurry && len(args) < len(sig.required) {
+		return scope.curried(name, tree, args)
+	}
+	if sig != nil && sig.check(scope, name, len(args)) {
+		vals := make([]*ast.Tree, len(args))
+		for i := 0; i < len(args); i++ {
+			vals[i] = scope.value(args[i])
 		}
+		return scope.apply(name, tree, sig, vals)
 	} else {
-		errorf("lambda: arg number incorrect")
 		return nil
 	}
 }
 
+// apply calls the lambda tree with sig, whose arguments have the values
+// vals
+func (scope *Scope) apply(name string, tree *ast.Tree, sig *signature, vals []*ast.Tree) *ast.Tree {
+	sc := scope.childScope()
+	sc.Add(&variable.Var{
+			Var: "self",
+			Tree: ast.CopyTree(tree, new(ast.Tree)),
+		})
+	if !sig.typed(scope, name, vals) {
+		return nil
+	}
+	// populate scope
+	if !sig.bind(name, sc, vals) {
+		return nil
+	}
+	return sc.checked(name, tree)
+}
+
+// value evaluates tree, keeping it as it is if it cannot be reduced
+func (scope *Scope) value(tree *ast.Tree) *ast.Tree {
+	if t := scope.eval(tree); t != nil {
+		return t
+	}
+	return tree
+}
+
+// force evaluates tree like eval, then evaluates the unevaluated tree
+// a variable holds, giving its value
+func (scope *Scope) force(tree *ast.Tree) *ast.Tree {
+	t := scope.eval(tree)
+	if t != nil && tree.Val.Typ == ast.ItemVar && (t.Val.Typ == ast.ItemKey && t.Val.Key != token.ItemFunction || isLiteral(t)) {
+		if v := scope.eval(t); v != nil {
+			return v
+		}
+	}
+	return t
+}
+
 func (scope *Scope) evalCmp(tree *ast.Tree) *ast.Tree {
 	if len(tree.Sub) == 3 {
 		t := scope.eval(tree.Sub[0])
-		if t != nil {
-			if t.Val.Typ == ast.ItemNum && t.Val.Num == 1 {
+		if t != nil && t.Val.Typ == ast.ItemNum {
+			if t.Val.Num == 1 {
 				return scope.eval(tree.Sub[1])
 			} else {
 				return scope.eval(tree.Sub[2])
 			}
 		} else {
+			// condition is unknown, keep both branches
+			if t != nil {
+				tree.Sub[0] = t
+			}
 			return nil
 		}
 	} else {
-		errorf("cmp: arg number incorrect")
+		scope.errorf("cmp: arg number incorrect")
+		return nil
+	}
+}
+
+// evaluates the children in order, giving the value of the last
+func (scope *Scope) evalBegin(tree *ast.Tree) *ast.Tree {
+	if len(tree.Sub) == 0 {
+		scope.errorf("begin: nothing to evaluate")
 		return nil
 	}
+	t := scope.evalChildren(tree)
+	return t.Sub[len(t.Sub)-1]
 }
 
 // stuff functions
@@ -216,13 +475,22 @@ var evalLookup = map[token.ItemType]eval{
 	//token.ItemMod: evalMod, // only integer
 	token.ItemEq:  evalEq,
 	token.ItemLt: evalLt,
+	token.ItemGt: evalGt,
+	token.ItemGe: evalGe,
+	token.ItemLe: evalLe,
+}
+
+// comparisons are the operations of evalLookup taking exactly two
+// operands.
+var comparisons = map[token.ItemType]bool{
+	token.ItemEq: true,
+	token.ItemLt: true,
+	token.ItemGt: true,
+	token.ItemGe: true,
+	token.ItemLe: true,
 }
 
 func evalEq(t *ast.Tree) (*ast.Tree) {
-	if len(t.Sub) != 2 {
-		errorf("eq takes two atoms")
-		return nil
-	}
 	var n float64 = 0
 	if t.Sub[0].Val.Num == t.Sub[1].Val.Num {
 		n = 1
@@ -236,10 +504,6 @@ func evalEq(t *ast.Tree) (*ast.Tree) {
 }
 
 func evalLt(t *ast.Tree) (*ast.Tree) {
-	if len(t.Sub) != 2 {
-		errorf("lt takes two atoms")
-		return nil
-	}
 	var n float64 = 0
 	if t.Sub[0].Val.Num < t.Sub[1].Val.Num {
 		n = 1
@@ -252,6 +516,18 @@ func evalLt(t *ast.Tree) (*ast.Tree) {
 	}
 }
 
+func evalGt(t *ast.Tree) (*ast.Tree) {
+	return truth(t.Sub[0].Val.Num > t.Sub[1].Val.Num)
+}
+
+func evalGe(t *ast.Tree) (*ast.Tree) {
+	return truth(t.Sub[0].Val.Num >= t.Sub[1].Val.Num)
+}
+
+func evalLe(t *ast.Tree) (*ast.Tree) {
+	return truth(t.Sub[0].Val.Num <= t.Sub[1].Val.Num)
+}
+
 func evalAdd(t *ast.Tree) (*ast.Tree) {
 	n := t.Sub[0].Val.Num
 	for i := 1; i < len(t.Sub); i++ {
@@ -0,0 +1,115 @@
+package optim
+
+import (
+	"testing"
+
+	"github.com/cptaffe/lang/parser"
+)
+
+// last evaluates s, returning the value of its last form.
+func last(s string) string {
+	tree := Eval(parser.Parse(s, "test"))
+	return tree.Sub[len(tree.Sub)-1].String()
+}
+
+// runTest is a program, run with Run, and the value of its last form,
+// or whether it stops at an error.
+type runTest struct {
+	in, want string
+	err      bool
+}
+
+// runAll runs each of tests with opts.
+func runAll(t *testing.T, opts Options, tests []runTest) {
+	for _, test := range tests {
+		tree, err := Run(parser.Parse(test.in, "test"), opts)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s returned no error", test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.in, err)
+		} else if got := tree.Sub[len(tree.Sub)-1].String(); got != test.want {
+			t.Errorf("%s = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestLambdaBody(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"(: f (lambda (list x) (+ x 1))) (f 3)", "4"},
+		{"(: f (lambda (list x) (+ x 1) (list x x))) (f 3)", "list{3, 3}"},
+		{"(: f (lambda (list x) (list x) (+ x 1))) (f 3)", "4"},
+		{"((lambda (list x) (* x x)) 3)", "9"},
+		// partial and curry build lambdas calling the function they are
+		// given
+		{"(: add (lambda (list a b) (+ a b))) (: inc (partial add 1)) (inc 2)", "3"},
+		{"(: add (lambda (list a b) (+ a b))) (((curry add) 1) 2)", "3"},
+	}
+	for _, test := range tests {
+		if got := last(test.in); got != test.want {
+			t.Errorf("%s = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestCurry(t *testing.T) {
+	const defs = "(: add (lambda (list a b) (+ a b))) (: add3 (lambda (list a b c) (+ a (* b c)))) "
+	runAll(t, Options{}, []runTest{
+		{in: defs + "((partial add) 1 2)", want: "3"},
+		{in: defs + "((partial add 1) 2)", want: "3"},
+		{in: defs + "((partial add3 1 2) 3)", want: "7"},
+		{in: defs + "((partial add3 1 2 3))", want: "7"},
+		{in: defs + "(partial add3 1 2 3 4)", err: true},
+		{in: defs + "(partial 1 2)", err: true},
+		{in: defs + "(((curry add) 1) 2)", want: "3"},
+		{in: defs + "((((curry add3) 1) 2) 3)", want: "7"},
+		{in: defs + "(: f (curry add3)) (: g (f 1)) ((g 2) 3)", want: "7"},
+		{in: defs + "(map (partial add 1) (list 1 2 3))", want: "list{2, 3, 4}"},
+		{in: defs + "(map (lambda (list f) ((f 2) 3)) (map (curry add3) (list 1 2)))", want: "list{7, 8}"},
+		// an under-applied call
+		{in: defs + "(add 1)", err: true},
+	})
+	runAll(t, Options{AutoCurry: true}, []runTest{
+		{in: defs + "((add 1) 2)", want: "3"},
+		{in: defs + "(((add3 1) 2) 3)", want: "7"},
+		{in: defs + "((add3 1 2) 3)", want: "7"},
+	})
+}
+
+func TestLists(t *testing.T) {
+	const rev = "(: rev (lambda (list l acc) (cmp (null? l) acc (rev (cdr l) (cons (car l) acc))))) "
+	runAll(t, Options{}, []runTest{
+		{in: "(cons 1 (list 2 3))", want: "list{1, 2, 3}"},
+		{in: "(cons (list) (list))", want: "list{list{}}"},
+		{in: "(car (list 1 2))", want: "1"},
+		{in: "(cdr (list 1 2))", want: "list{2}"},
+		{in: "(cdr (list 1))", want: "list{}"},
+		{in: "(length (list))", want: "0"},
+		{in: "(length (list 1 (list 2 3)))", want: "2"},
+		{in: "(append (list 1) (list) (list 2 3))", want: "list{1, 2, 3}"},
+		{in: "(append)", want: "list{}"},
+		{in: "(nth 1 (list 1 2))", want: "2"},
+		{in: "(null? (list))", want: "1"},
+		{in: "(null? (list 1))", want: "0"},
+		{in: "(list? (list))", want: "1"},
+		{in: "(list? 1)", want: "0"},
+		{in: rev + "(rev (list 1 2 3) (list))", want: "list{3, 2, 1}"},
+		{in: rev + "(rev (list) (list))", want: "list{}"},
+		// errors
+		{in: "(car (list))", err: true},
+		{in: "(cdr (list))", err: true},
+		{in: "(car 1)", err: true},
+		{in: `(cdr "ab")`, err: true},
+		{in: "(car (list 1) (list 2))", err: true},
+		{in: "(cons 1 2)", err: true},
+		{in: "(length 1)", err: true},
+		{in: "(append (list 1) 2)", err: true},
+		{in: "(nth 5 (list 1))", err: true},
+		{in: "(null? 1 2)", err: true},
+	})
+}
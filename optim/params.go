@@ -0,0 +1,219 @@
+package optim
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+	"github.com/cptaffe/lang/variable"
+)
+
+// signature is a parsed parameter list: required names or list
+// patterns, then optional (name default) pairs, then an optional &rest
+// (or .) name collecting the remaining arguments as a list.
+type signature struct {
+	required []*ast.Tree // names and patterns
+	optional []*ast.Tree // (name default) pairs
+	rest     string      // "" if there is no rest parameter
+}
+
+// isRestMarker reports whether tree is the &rest or . before a rest
+// parameter.
+func isRestMarker(tree *ast.Tree) bool {
+	return tree.Val.Typ == ast.ItemVar && (tree.Val.Var == "&rest" || tree.Val.Var == ".")
+}
+
+// isOptional reports whether tree is an optional (name default) pair.
+func isOptional(tree *ast.Tree) bool {
+	return isKey(tree, token.ItemLambda) && len(tree.Sub) == 1
+}
+
+// params parses the parameter list of a lambda or macro, reporting an
+// error and returning nil if it is malformed.
+func (scope *Scope) params(list *ast.Tree) *signature {
+	sig := new(signature)
+	ps := list.Sub
+	for i := 0; i < len(ps); i++ {
+		switch p := ps[i]; {
+		case isRestMarker(p):
+			if i != len(ps)-2 || ps[i+1].Val.Typ != ast.ItemVar || isRestMarker(ps[i+1]) {
+				scope.errorf("%s must be followed by one last parameter in %s", p.Val.Var, list)
+				return nil
+			}
+			sig.rest = ps[i+1].Val.Var
+			return sig
+		case p.Val.Typ == ast.ItemVar || isKey(p, token.ItemList):
+			if len(sig.optional) > 0 {
+				scope.errorf("required parameter %s after optional ones in %s", p, list)
+				return nil
+			}
+			if !scope.checkPattern(p) {
+				return nil
+			}
+			sig.required = append(sig.required, p)
+		case isOptional(p):
+			sig.optional = append(sig.optional, p)
+		default:
+			scope.errorf("incorrect parameter %s in %s", p, list)
+			return nil
+		}
+	}
+	return sig
+}
+
+// simple reports whether a parameter list only has required names,
+// without type annotations.
+func simple(list *ast.Tree) bool {
+	for _, p := range list.Sub {
+		if p.Val.Typ != ast.ItemVar || isRestMarker(p) || p.Val.Type != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// paramNames returns the nodes naming the parameters in list,
+// including the variables in its patterns.
+func paramNames(list *ast.Tree) []*ast.Tree {
+	var names []*ast.Tree
+	for _, p := range list.Sub {
+		switch {
+		case isKey(p, token.ItemList):
+			names = append(names, patternVars(p)...)
+		case !isRestMarker(p):
+			names = append(names, p)
+		}
+	}
+	return names
+}
+
+// arity describes how many arguments sig takes, e.g. "1 to 2 arguments".
+func (sig *signature) arity() string {
+	min, max := len(sig.required), len(sig.required)+len(sig.optional)
+	s := fmt.Sprintf("%d to %d", min, max)
+	switch {
+	case sig.rest != "":
+		s, max = fmt.Sprintf("at least %d", min), min
+	case min == max:
+		s = fmt.Sprint(min)
+	}
+	if max == 1 {
+		return s + " argument"
+	}
+	return s + " arguments"
+}
+
+// check reports whether sig takes n arguments, reporting an arity error
+// for name if it doesn't.
+func (sig *signature) check(scope *Scope, name string, n int) bool {
+	if n < len(sig.required) || sig.rest == "" && n > len(sig.required)+len(sig.optional) {
+		scope.errorf("%s takes %s, got %d", name, sig.arity(), n)
+		return false
+	}
+	return true
+}
+
+// typed reports whether the known values among vals are of the types
+// the parameters of sig they are passed for are annotated with,
+// reporting an error for name about the first that isn't.
+func (sig *signature) typed(scope *Scope, name string, vals []*ast.Tree) bool {
+	ps := append(append([]*ast.Tree(nil), sig.required...), sig.optional...)
+	for i := 0; i < len(vals) && i < len(ps); i++ {
+		if !typed(scope, name, "argument", ps[i], vals[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// typed reports whether v, what is bound to the parameter p, is of the
+// type p is annotated with, if it is known, reporting an error for name
+// if it isn't.
+func typed(scope *Scope, name, what string, p, v *ast.Tree) bool {
+	if p.Val.Type == "" || !isValue(v) && !isFunction(v) || conforms(v, p.Val.Type) {
+		return true
+	}
+	scope.errorf("%s: %s %s = %s is not %s", name, what, p.Val.Var, v, p.Val.Type)
+	return false
+}
+
+// conforms reports whether the value v is of the type named typ, one of
+// ast.TypeNames or a type defrecord or deftype defined.
+func conforms(v *ast.Tree, typ string) bool {
+	switch typ {
+	case "num":
+		return v.Val.Typ == ast.ItemNum
+	case "int":
+		return v.Val.Typ == ast.ItemNum && v.Val.Num == math.Trunc(v.Val.Num)
+	case "str":
+		return isString(v)
+	case "atom":
+		return v.Val.Typ == ast.ItemAtom
+	case "sym":
+		return v.Val.Typ == ast.ItemSym
+	case "list":
+		return isList(v)
+	case "vector":
+		return isVector(v)
+	case "map":
+		return isMap(v)
+	case "fn":
+		return isFunction(v)
+	case "any":
+		return true
+	}
+	return isRecord(v) && (v.Val.Rec.Type.Name == typ || v.Val.Rec.Sum != nil && v.Val.Rec.Sum.Name == typ)
+}
+
+// bind binds the parameters of sig in sc to args, which sig must take,
+// reporting whether it could. Arguments are destructured by the list
+// patterns they are passed for, an argument that doesn't match is an
+// error for name. Missing optional arguments get their defaults,
+// evaluated in sc so that they can use the parameters before them.
+func (sig *signature) bind(name string, sc *Scope, args []*ast.Tree) bool {
+	for i, p := range sig.required {
+		if p.Val.Typ == ast.ItemVar {
+			sc.Add(&variable.Var{Var: p.Val.Var, Tree: args[i]})
+			continue
+		}
+		v := args[i]
+		if !isValue(v) {
+			v = sc.value(v)
+		}
+		if !isValue(v) {
+			// not known yet
+			return false
+		}
+		binds, ok := match(p, v, nil)
+		if !ok {
+			sc.errorf("%s: argument %s doesn't match %s", name, v, p)
+			return false
+		}
+		for _, b := range binds {
+			sc.Add(b)
+		}
+	}
+	n := len(sig.required)
+	for _, opt := range sig.optional {
+		var val *ast.Tree
+		if n < len(args) {
+			val = args[n]
+		} else {
+			val = sc.value(ast.CopyTree(opt.Sub[0], new(ast.Tree)))
+			if !typed(sc, name, "default", opt, val) {
+				return false
+			}
+		}
+		sc.Add(&variable.Var{Var: opt.Val.Var, Tree: val})
+		n++
+	}
+	if sig.rest != "" {
+		var rest []*ast.Tree
+		if n < len(args) {
+			rest = args[n:]
+		}
+		sc.Add(&variable.Var{Var: sig.rest, Tree: newList(rest)})
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package optim
+
+import (
+	"testing"
+
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/parser"
+)
+
+func TestAnnotations(t *testing.T) {
+	const sq = "(: sq (lambda (list (n : int)) (* n n))) "
+	const f = "(: f (lambda (list a (n : int 5)) (+ a n))) "
+	tests := []struct {
+		in, want string
+		err      bool
+	}{
+		{sq + "(sq 3)", "9", false},
+		{sq + "(sq 2.5)", "", true},
+		{f + "(f 1)", "6", false},
+		{f + "(f 1 2)", "3", false},
+		{f + "(f 1 2.5)", "", true},
+		{"(: f (lambda (list (n : int 1.5)) n)) (f)", "", true},
+		// without the colon, it is an optional parameter
+		{"(: int 2) (: f (lambda (list (n int)) n)) (f)", "2", false},
+		{"(: (x : int) 5) (+ x 0)", "5", false},
+		{"(: (x : str) 5)", "", true},
+		{"(defrecord pt (list x y)) (: f (lambda (list (p : pt)) 1)) (f (pt 1 2))", "1", false},
+		{"(defrecord pt (list x y)) (: f (lambda (list (p : pt)) 1)) (f 1)", "", true},
+		// lambdas a macro gives are annotated too
+		{"(macro deff (list name) `(: ,name (lambda (list (n : int)) n))) (deff g) (g 1)", "1", false},
+		{"(macro deff (list name) `(: ,name (lambda (list (n : int)) n))) (deff g) (g 1.5)", "", true},
+		{"(macro deff (list name) `(: ,name (lambda (list (n : intt)) n))) (deff g)", "", true},
+		{"(macro deff (list name) `(: ,name (lambda (list (n : int 1 2)) n))) (deff g)", "", true},
+	}
+	for _, test := range tests {
+		tree, err := Run(parser.Parse(test.in, "test"), Options{})
+		if test.err {
+			if err == nil {
+				t.Errorf("%s returned no error", test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.in, err)
+		} else if got := tree.Sub[len(tree.Sub)-1].String(); got != test.want {
+			t.Errorf("%s = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestAnnotationSyntax(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"(lambda (list (n : int) (m : str 1)) n)", "list{(n : int), unk{1}}"},
+		{"(lambda (list (n int)) n)", "list{unk{(int)}}"},
+	}
+	for _, test := range tests {
+		if got := form(t, test.in).Sub[0].String(); got != test.want {
+			t.Errorf("%s has parameters %s, want %s", test.in, got, test.want)
+		}
+	}
+	// an unknown type is an error, leaving the annotation as it is
+	for _, in := range []string{"(lambda (list (n : integr)) n)", "(: (x : strr) 1)", "(lambda (list (n : int 1 2)) n)"} {
+		tree := parser.Parse(in, "test")
+		if len(tree.Sub) == 1 {
+			walk(tree.Sub[0], func(n *ast.Tree) bool {
+				if n.Val.Type != "" {
+					t.Errorf("%s annotated %s", in, n)
+				}
+				return true
+			})
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package optim
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/cptaffe/lang/ast"
+)
+
+// Pass is one rewrite of a program tree.
+type Pass interface {
+	Name() string // name the pass is selected by
+	// Run rewrites tree, returning the new root and whether it changed
+	// anything.
+	Run(tree *ast.Tree) (*ast.Tree, bool)
+}
+
+// FoldPass runs Fold.
+type FoldPass struct{}
+
+func (FoldPass) Name() string { return "fold" }
+
+func (FoldPass) Run(tree *ast.Tree) (*ast.Tree, bool) {
+	changed := false
+	tree = fold(tree, &changed)
+	return tree, changed
+}
+
+// DCEPass runs DCE, keeping everything it removed.
+type DCEPass struct {
+	Removed []Removal
+}
+
+func (p *DCEPass) Name() string { return "dce" }
+
+func (p *DCEPass) Run(tree *ast.Tree) (*ast.Tree, bool) {
+	tree, rm := DCE(tree)
+	p.Removed = append(p.Removed, rm...)
+	return tree, len(rm) > 0
+}
+
+// InlinePass runs an Inliner, keeping every decision it made.
+type InlinePass struct {
+	Inliner
+	Decisions []Decision
+}
+
+func (p *InlinePass) Name() string { return "inline" }
+
+func (p *InlinePass) Run(tree *ast.Tree) (*ast.Tree, bool) {
+	tree, ds := p.Inline(tree)
+	p.Decisions = append(p.Decisions, ds...)
+	for _, d := range ds {
+		if d.Inlined {
+			return tree, true
+		}
+	}
+	return tree, false
+}
+
+// Passes creates each pass by name.
+var Passes = map[string]func() Pass{
+	"fold": func() Pass { return FoldPass{} },
+	"dce":  func() Pass { return new(DCEPass) },
+	"inline": func() Pass {
+		return &InlinePass{Inliner: DefaultInliner}
+	},
+}
+
+// DefaultMaxRuns is how many times a PassManager runs its pipeline
+// looking for a fixpoint when not told otherwise.
+const DefaultMaxRuns = 8
+
+// PassManager runs a pipeline of passes, in order, until the tree stops
+// changing.
+type PassManager struct {
+	Passes  []Pass
+	MaxRuns int       // most runs of the pipeline, DefaultMaxRuns if 0
+	Dump    io.Writer // if set, the tree is dumped here after each pass
+}
+
+// NewPassManager returns a PassManager running the named passes in
+// order, e.g. NewPassManager("fold", "inline", "dce").
+func NewPassManager(names ...string) (*PassManager, error) {
+	pm := new(PassManager)
+	for _, name := range names {
+		p, ok := Passes[name]
+		if !ok {
+			return nil, fmt.Errorf("optim: unknown pass %q", name)
+		}
+		pm.Passes = append(pm.Passes, p())
+	}
+	return pm, nil
+}
+
+// Run expands the macros in tree, so that the passes see the code
+// macros generate and the names it uses, then runs the pipeline over it
+// until no pass of a whole run changes anything or MaxRuns is reached,
+// returning the new root.
+func (pm *PassManager) Run(tree *ast.Tree) *ast.Tree {
+	max := pm.MaxRuns
+	if max == 0 {
+		max = DefaultMaxRuns
+	}
+	tree = Expand(tree)
+	for run := 1; run <= max; run++ {
+		changed := false
+		for _, p := range pm.Passes {
+			var c bool
+			tree, c = p.Run(tree)
+			changed = changed || c
+			if pm.Dump != nil {
+				fmt.Fprintf(pm.Dump, "after %s (run %d): %s\n", p.Name(), run, tree)
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return tree
+}
@@ -0,0 +1,56 @@
+package optim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cptaffe/lang/parser"
+)
+
+func TestPassManagerRuns(t *testing.T) {
+	tests := []struct {
+		in     string
+		passes []string
+		runs   int
+	}{
+		{"(+ x 0)", []string{"fold"}, 1},
+		{"(+ 1 2)", []string{"fold"}, 2},
+		{"(: f (lambda (list a) (* a 1))) (f x)", []string{"fold", "inline"}, 2},
+		{"(: f (lambda (list a) a)) (f 1)", []string{"inline", "dce"}, 2},
+	}
+	for _, test := range tests {
+		pm, err := NewPassManager(test.passes...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dump bytes.Buffer
+		pm.Dump = &dump
+		pm.Run(parser.Parse(test.in, "test"))
+		runs := strings.Count(dump.String(), "\n") / len(test.passes)
+		if runs != test.runs {
+			t.Errorf("%v over %s: %d runs, want %d\n%s", test.passes, test.in, runs, test.runs, dump.String())
+		}
+	}
+}
+
+func TestPassRunChanged(t *testing.T) {
+	tests := []struct {
+		pass, in string
+		changed  bool
+	}{
+		{"fold", "(+ 1 2)", true},
+		{"fold", "(* (+ x 2) 1)", true},
+		{"fold", "(* x 1)", false},
+		{"fold", "(+ x 1)", false},
+		{"dce", "(: x 1) (+ y 1)", true},
+		{"dce", "(: x 1) (+ x 1)", false},
+		{"inline", "(: f (lambda (list a) a)) (f 1)", true},
+		{"inline", "(: f (lambda (list a) (f a))) (f 1)", false},
+	}
+	for _, test := range tests {
+		if _, changed := Passes[test.pass]().Run(parser.Parse(test.in, "test")); changed != test.changed {
+			t.Errorf("%s over %s changed = %v, want %v", test.pass, test.in, changed, test.changed)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package optim
+
+import (
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+)
+
+// evalQuote returns quoted data as it is, with vector and map literals
+// made values.
+func (scope *Scope) evalQuote(tree *ast.Tree) *ast.Tree {
+	if len(tree.Sub) != 1 {
+		scope.errorf("quote takes one argument")
+		return nil
+	}
+	return scope.data(tree.Sub[0])
+}
+
+// evalQuasiquote returns quoted data with the unquoted parts replaced
+// by their values. It is left as it is while any of them is unknown.
+func (scope *Scope) evalQuasiquote(tree *ast.Tree) *ast.Tree {
+	if len(tree.Sub) != 1 {
+		scope.errorf("quasiquote takes one argument")
+		return nil
+	}
+	t, _ := scope.quasi(tree.Sub[0], 1)
+	return scope.data(t)
+}
+
+// quasi copies the data in tree, replacing anything unquoted at depth
+// 1 by its value. Nested quasiquotes go one level deeper.
+func (scope *Scope) quasi(tree *ast.Tree, depth int) (*ast.Tree, bool) {
+	switch {
+	case isKey(tree, token.ItemUnquote) || isKey(tree, token.ItemUnquoteSplicing):
+		if len(tree.Sub) != 1 {
+			scope.errorf("unquote takes one argument")
+			return nil, false
+		}
+		if depth == 1 {
+			t := scope.force(tree.Sub[0])
+			return t, t != nil
+		}
+		depth--
+	case isKey(tree, token.ItemQuasiquote):
+		depth++
+	}
+	t := &ast.Tree{Val: tree.Val}
+	for i := 0; i < len(tree.Sub); i++ {
+		sub, ok := scope.quasi(tree.Sub[i], depth)
+		if !ok {
+			return nil, false
+		}
+		if depth == 1 && isKey(tree.Sub[i], token.ItemUnquoteSplicing) {
+			if sub.Val.Typ != ast.ItemList {
+				scope.errorf("unquote-splicing of non-list %s", sub)
+				return nil, false
+			}
+			t.Sub = append(t.Sub, sub.Sub...)
+		} else {
+			t.Sub = append(t.Sub, sub)
+		}
+	}
+	return t, true
+}
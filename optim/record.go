@@ -0,0 +1,188 @@
+package optim
+
+import (
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+)
+
+// A top level (defrecord name (list field...)) defines the record type
+// name by assigning, like Racket's struct, the constructor (name
+// field...), the predicate (name? x) and an accessor (name-field r) for
+// each field. They are written with the record, record? and field
+// builtins, which take the type and fields as atoms.
+//
+// A top level (deftype name (variant field...)...) defines the sum type
+// name, whose values are one of its variants. Each variant is defined
+// like a record, except that its constructor makes the value with the
+// variant builtin, which marks it as being of name, and (name? x) tests
+// for any of them.
+
+// defrecord returns the assigns a defrecord form stands for.
+func (scope *Scope) defrecord(tree *ast.Tree) []*ast.Tree {
+	if len(tree.Sub) != 2 || tree.Sub[0].Val.Typ != ast.ItemVar || !isKey(tree.Sub[1], token.ItemList) {
+		scope.errorf("incorrect defrecord syntax %s", tree)
+		return nil
+	}
+	return scope.recordDefs(tree.Sub[0].Val.Var, tree.Sub[1].Sub, "")
+}
+
+// deftype returns the assigns a deftype form stands for.
+func (scope *Scope) deftype(tree *ast.Tree) []*ast.Tree {
+	if len(tree.Sub) < 2 || tree.Sub[0].Val.Typ != ast.ItemVar {
+		scope.errorf("incorrect deftype syntax %s", tree)
+		return nil
+	}
+	name := tree.Sub[0].Val.Var
+	defs := []*ast.Tree{
+		assign(name+"?", call(token.ItemFunction,
+			call(token.ItemList, ref("x")),
+			call(token.ItemIsVariant, atom(name), ref("x")))),
+	}
+	for _, v := range tree.Sub[1:] {
+		if !isKey(v, token.ItemLambda) {
+			scope.errorf("incorrect variant %s of %s, variants are written (name field...)", v, name)
+			return nil
+		}
+		d := scope.recordDefs(v.Val.Var, v.Sub, name)
+		if d == nil {
+			return nil
+		}
+		defs = append(defs, d...)
+	}
+	return defs
+}
+
+// recordDefs returns the assigns defining the record type name with
+// fields, which is a variant of the sum type sum unless sum is "".
+func (scope *Scope) recordDefs(name string, fields []*ast.Tree, sum string) []*ast.Tree {
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		if f.Val.Typ != ast.ItemVar || isRestMarker(f) || seen[f.Val.Var] {
+			scope.errorf("incorrect field %s of %s", f, name)
+			return nil
+		}
+		seen[f.Val.Var] = true
+	}
+	// (name field...) is (record :name :field field...)
+	var params []*ast.Tree
+	build := call(token.ItemRecord, atom(name))
+	if sum != "" {
+		build = call(token.ItemVariant, atom(sum), atom(name))
+	}
+	for _, f := range fields {
+		params = append(params, ref(f.Val.Var))
+		build.Sub = append(build.Sub, atom(f.Val.Var), ref(f.Val.Var))
+	}
+	defs := []*ast.Tree{
+		assign(name, call(token.ItemFunction, call(token.ItemList, params...), build)),
+		assign(name+"?", call(token.ItemFunction,
+			call(token.ItemList, ref("r")),
+			call(token.ItemIsRecord, atom(name), ref("r")))),
+	}
+	for _, f := range fields {
+		defs = append(defs, assign(name+"-"+f.Val.Var, call(token.ItemFunction,
+			call(token.ItemList, ref("r")),
+			call(token.ItemField, atom(name), atom(f.Val.Var), ref("r")))))
+	}
+	return defs
+}
+
+// atom returns the keyword atom named name.
+func atom(name string) *ast.Tree {
+	return &ast.Tree{Val: &ast.Node{Typ: ast.ItemAtom, Atom: ast.Intern(name)}}
+}
+
+// ref returns a reference to the variable name.
+func ref(name string) *ast.Tree {
+	return &ast.Tree{Val: &ast.Node{Typ: ast.ItemVar, Var: name}}
+}
+
+// assign returns the code assigning val to name.
+func assign(name string, val *ast.Tree) *ast.Tree {
+	return call(token.ItemAssign, ref(name), val)
+}
+
+// isRecord reports whether tree is a record value.
+func isRecord(tree *ast.Tree) bool {
+	return tree.Val.Typ == ast.ItemRecord
+}
+
+// newRecord returns the record (name :type :field value...) makes,
+// reporting errors for name.
+func (scope *Scope) newRecord(name string, args []*ast.Tree) *ast.Tree {
+	if len(args) == 0 || len(args)%2 == 0 || args[0].Val.Typ != ast.ItemAtom {
+		scope.errorf("%s takes a type and fields each followed by a value", name)
+		return nil
+	}
+	rec := &ast.Record{Type: args[0].Val.Atom}
+	for i := 1; i < len(args); i += 2 {
+		f := args[i]
+		if f.Val.Typ != ast.ItemAtom {
+			scope.errorf("%s field %s is not an atom", name, f)
+			return nil
+		}
+		if _, ok := rec.Field(f.Val.Atom); ok {
+			scope.errorf("%s field %s given twice", name, f)
+			return nil
+		}
+		rec.Fields = append(rec.Fields, f.Val.Atom)
+		rec.Vals = append(rec.Vals, args[i+1])
+	}
+	return &ast.Tree{Val: &ast.Node{Typ: ast.ItemRecord, Rec: rec}}
+}
+
+// (record :type :field value...) is a record of type with the fields in
+// order.
+func evalRecord(scope *Scope, args []*ast.Tree) *ast.Tree {
+	return scope.newRecord("record", args)
+}
+
+func evalIsRecord(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 2 || args[0].Val.Typ != ast.ItemAtom {
+		scope.errorf("record? takes a type and a value")
+		return nil
+	}
+	return truth(isRecord(args[1]) && args[1].Val.Rec.Type == args[0].Val.Atom)
+}
+
+// (field :type :field r) is the field of r, which must be a record of
+// type.
+func evalField(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 3 || args[0].Val.Typ != ast.ItemAtom || args[1].Val.Typ != ast.ItemAtom {
+		scope.errorf("field takes a type, a field and a record")
+		return nil
+	}
+	typ, f, r := args[0].Val.Atom, args[1].Val.Atom, args[2]
+	if !isRecord(r) || r.Val.Rec.Type != typ {
+		scope.errorf("%s-%s of %s, which is not a %s", typ.Name, f.Name, r, typ.Name)
+		return nil
+	}
+	v, ok := r.Val.Rec.Field(f)
+	if !ok {
+		scope.errorf("%s has no field %s", typ.Name, f.Name)
+		return nil
+	}
+	return v
+}
+
+// (variant :sum :type :field value...) is a record of type, a variant
+// of the sum type sum.
+func evalVariant(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) < 2 || args[0].Val.Typ != ast.ItemAtom {
+		scope.errorf("variant takes a sum type, a type and fields each followed by a value")
+		return nil
+	}
+	t := scope.newRecord("variant", args[1:])
+	if t != nil {
+		t.Val.Rec.Sum = args[0].Val.Atom
+	}
+	return t
+}
+
+func evalIsVariant(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 2 || args[0].Val.Typ != ast.ItemAtom {
+		scope.errorf("variant? takes a sum type and a value")
+		return nil
+	}
+	return truth(isRecord(args[1]) && args[1].Val.Rec.Sum == args[0].Val.Atom)
+}
@@ -0,0 +1,30 @@
+package optim
+
+import "testing"
+
+func TestDefrecord(t *testing.T) {
+	const pt = "(defrecord pt (list x y)) "
+	runAll(t, Options{}, []runTest{
+		// the constructor
+		{in: pt + "(pt 1 2)", want: "pt{x 1, y 2}"},
+		{in: pt + `(pt "a" (list 1))`, want: `pt{x "a", y list{1}}`},
+		{in: "(defrecord unit (list)) (unit)", want: "unit{}"},
+		{in: pt + "(= (pt 1 2) (pt 1 2))", want: "1"},
+		{in: pt + "(= (pt 1 2) (pt 2 1))", want: "0"},
+		// the predicate
+		{in: pt + "(list (pt? (pt 1 2)) (pt? 1) (pt? (list 1 2)))", want: "list{1, 0, 0}"},
+		{in: pt + "(defrecord sz (list x y)) (pt? (sz 1 2))", want: "0"},
+		// the accessors
+		{in: pt + "(: p (pt 1 2)) (list (pt-x p) (pt-y p))", want: "list{1, 2}"},
+		{in: pt + "(pt-y (pt 1 (pt 2 3)))", want: "pt{x 2, y 3}"},
+		{in: pt + "(field :pt :x (pt 3 4))", want: "3"},
+		// errors
+		{in: pt + "(pt 1)", err: true},
+		{in: pt + "(pt 1 2 3)", err: true},
+		{in: pt + "(pt-x 1)", err: true},
+		{in: pt + "(defrecord sz (list x y)) (pt-x (sz 1 2))", err: true},
+		{in: "(defrecord pt (list x x))", err: true},
+		{in: "(defrecord pt (list 1))", err: true},
+		{in: "(defrecord pt)", err: true},
+	})
+}
@@ -0,0 +1,232 @@
+package optim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cptaffe/lang/ast"
+)
+
+// String builtins count and index in characters, not bytes.
+
+// str returns a string.
+func str(s string) *ast.Tree {
+	return &ast.Tree{
+		Val: &ast.Node{
+			Typ: ast.ItemString,
+			Str: s,
+		},
+	}
+}
+
+// isString reports whether tree is a string value.
+func isString(tree *ast.Tree) bool {
+	return tree.Val.Typ == ast.ItemString
+}
+
+// strs checks that args are n strings, reporting an error for name
+// otherwise.
+func (scope *Scope) strs(name string, args []*ast.Tree, n int) bool {
+	if len(args) != n {
+		scope.errorf("%s takes %d arguments", name, n)
+		return false
+	}
+	for i := 0; i < n; i++ {
+		if !isString(args[i]) {
+			scope.errorf("%s of non-string %s", name, args[i])
+			return false
+		}
+	}
+	return true
+}
+
+// index returns a number as an index, reporting an error for name if it
+// isn't a whole number.
+func (scope *Scope) index(name string, tree *ast.Tree) (int, bool) {
+	n := tree.Val.Num
+	if tree.Val.Typ != ast.ItemNum || n != float64(int(n)) {
+		scope.errorf("%s index %s is not a whole number", name, tree)
+		return 0, false
+	}
+	return int(n), true
+}
+
+func evalConcat(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if !scope.strs("concat", args, len(args)) {
+		return nil
+	}
+	var b strings.Builder
+	for _, a := range args {
+		b.WriteString(a.Val.Str)
+	}
+	return str(b.String())
+}
+
+func evalStrlen(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if !scope.strs("strlen", args, 1) {
+		return nil
+	}
+	return num(float64(utf8.RuneCountInString(args[0].Val.Str)))
+}
+
+// (substr s start end) is s from character start up to, but not
+// including, character end, or up to its end without one.
+func evalSubstr(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) < 2 || len(args) > 3 || !isString(args[0]) {
+		scope.errorf("substr takes a string, a start and an optional end")
+		return nil
+	}
+	s := []rune(args[0].Val.Str)
+	start, ok := scope.index("substr", args[1])
+	if !ok {
+		return nil
+	}
+	end := len(s)
+	if len(args) == 3 {
+		if end, ok = scope.index("substr", args[2]); !ok {
+			return nil
+		}
+	}
+	if start < 0 || end > len(s) || start > end {
+		scope.errorf("substr range %d to %d out of range for %s", start, end, args[0])
+		return nil
+	}
+	return str(string(s[start:end]))
+}
+
+// (split s sep) is the list of the strings between each sep in s, or of
+// the characters in s if sep is empty.
+func evalSplit(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if !scope.strs("split", args, 2) {
+		return nil
+	}
+	var elems []*ast.Tree
+	for _, s := range strings.Split(args[0].Val.Str, args[1].Val.Str) {
+		elems = append(elems, str(s))
+	}
+	return newList(elems)
+}
+
+// (join l sep) joins a list of strings with sep between them.
+func evalJoin(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 2 || !isList(args[0]) || !isString(args[1]) {
+		scope.errorf("join takes a list of strings and a separator")
+		return nil
+	}
+	if !scope.strs("join", args[0].Sub, len(args[0].Sub)) {
+		return nil
+	}
+	ss := make([]string, len(args[0].Sub))
+	for i, s := range args[0].Sub {
+		ss[i] = s.Val.Str
+	}
+	return str(strings.Join(ss, args[1].Val.Str))
+}
+
+func evalUpper(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if !scope.strs("upper", args, 1) {
+		return nil
+	}
+	return str(strings.ToUpper(args[0].Val.Str))
+}
+
+func evalLower(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if !scope.strs("lower", args, 1) {
+		return nil
+	}
+	return str(strings.ToLower(args[0].Val.Str))
+}
+
+func evalContains(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if !scope.strs("contains", args, 2) {
+		return nil
+	}
+	return truth(strings.Contains(args[0].Val.Str, args[1].Val.Str))
+}
+
+// (format f args...) formats args with the Go verbs in f. Whole numbers
+// are passed to integer verbs like %d as integers.
+func evalFormat(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) == 0 || !isString(args[0]) {
+		scope.errorf("format takes a format string and its arguments")
+		return nil
+	}
+	verbs := formatVerbs(args[0].Val.Str)
+	vals := make([]interface{}, len(args)-1)
+	for i, a := range args[1:] {
+		verb := 'v'
+		if i < len(verbs) {
+			verb = verbs[i]
+		}
+		vals[i] = goValue(a, verb)
+	}
+	return str(fmt.Sprintf(args[0].Val.Str, vals...))
+}
+
+// formatVerbs returns the verbs in a format string in order, ignoring
+// %%, with a '*' for each width or precision taken from an argument.
+// Verbs after an explicit argument index are not returned.
+func formatVerbs(f string) []rune {
+	var verbs []rune
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			continue
+		}
+		// flags, width and precision
+		for i++; i < len(f) && strings.IndexByte("+-# 0.*123456789", f[i]) >= 0; i++ {
+			if f[i] == '*' {
+				verbs = append(verbs, '*')
+			}
+		}
+		if i == len(f) || f[i] == '[' {
+			break
+		}
+		if f[i] != '%' {
+			r, n := utf8.DecodeRuneInString(f[i:])
+			verbs = append(verbs, r)
+			i += n - 1
+		}
+	}
+	return verbs
+}
+
+// goValue returns the Go value a formats as with verb, or as a width
+// or precision for '*'.
+func goValue(a *ast.Tree, verb rune) interface{} {
+	switch a.Val.Typ {
+	case ast.ItemNum:
+		if strings.ContainsRune("*bcdoOqxXU", verb) && a.Val.Num == float64(int64(a.Val.Num)) {
+			return int64(a.Val.Num)
+		}
+		return a.Val.Num
+	case ast.ItemString:
+		return a.Val.Str
+	}
+	return a.String()
+}
+
+// (->string x) is x written as a string.
+func evalToString(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if len(args) != 1 {
+		scope.errorf("->string takes 1 argument")
+		return nil
+	}
+	if isString(args[0]) {
+		return args[0]
+	}
+	return str(args[0].String())
+}
+
+func evalToNumber(scope *Scope, args []*ast.Tree) *ast.Tree {
+	if !scope.strs("string->number", args, 1) {
+		return nil
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(args[0].Val.Str), 64)
+	if err != nil {
+		scope.errorf("string->number of %s: not a number", args[0])
+		return nil
+	}
+	return num(n)
+}
@@ -0,0 +1,37 @@
+package optim
+
+import "testing"
+
+func TestFormatVerbs(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"%d %s", "ds"},
+		{"100%% %v", "v"},
+		{"%5.2f", "f"},
+		{"%*d", "*d"},
+		{"%-*.*f %x", "**fx"},
+		{"%d %[1]d %s", "d"},
+	}
+	for _, test := range tests {
+		if got := string(formatVerbs(test.in)); got != test.want {
+			t.Errorf("formatVerbs(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`(format "%d: %s" 1 "a")`, `"1: a"`},
+		{`(format "%*d|" 5 42)`, `"   42|"`},
+		{`(format "%-*s|%.*f" 4 "ab" 2 3.14159)`, `"ab  |3.14"`},
+		{`(format "%x %v" 255 (list 1 2))`, `"ff list{1, 2}"`},
+	}
+	for _, test := range tests {
+		if got := last(test.in); got != test.want {
+			t.Errorf("%s = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
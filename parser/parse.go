@@ -23,9 +23,18 @@ type parser struct {
 	items      chan token.Token // channel of scanned items
 	buff []token.Token // buffer is an array of tokens
 	pos int // pos is the location in buff
-	tree       *ast.Tree            // tree position
+	stack      []frame          // trees being parsed, innermost last
+	head       bool             // a code list waits for its keyword
 	Root       *ast.Tree            // tree position
 	parenDepth int              // nesting depth of ( ) exprs
+	types      map[string]bool  // names of the types defined so far
+}
+
+// frame is a tree being parsed
+type frame struct {
+	tree   *ast.Tree
+	data   bool // elements are quoted data, not code
+	prefix bool // quote prefix, closed after one element
 }
 
 func Parse(s string, name string) *ast.Tree {
@@ -35,8 +44,9 @@ func Parse(s string, name string) *ast.Tree {
 		name: l.Name,
 		input: s,
 		items: l.Items,
-		tree:  tree,
+		stack: []frame{{tree: tree}},
 		Root:  tree,
+		types: make(map[string]bool),
 	}
 	return p.run()
 }
@@ -72,6 +82,11 @@ func (p *parser) charNumber(tok token.Token) int {
 	return int(tok.Pos) - strings.LastIndex(p.input[:tok.Pos], "\n")
 }
 
+// get the position of a token
+func (p *parser) position(tok token.Token) ast.Pos {
+	return ast.Pos{Line: p.lineNumber(tok), Col: p.charNumber(tok)}
+}
+
 // errorf returns an error token and terminates the scan by passing
 // back a nil pointer that will be the next state, terminating l.nextItem.
 func (p *parser) errorf(format string, args ...interface{}) stateFn {
@@ -100,6 +115,18 @@ func parseAll(p *parser) stateFn {
 			return nil
 		case tok.Typ == token.ItemBeginList:
 			p.parenDepth++
+			p.openList()
+			return parseInsideList
+		case tok.Typ == token.ItemBeginVector:
+			p.parenDepth++
+			p.openLiteral(ast.ItemVector)
+			return parseInsideList
+		case tok.Typ == token.ItemBeginMap:
+			p.parenDepth++
+			p.openLiteral(ast.ItemMap)
+			return parseInsideList
+		case token.Quote(tok.Typ):
+			p.openPrefix(tok)
 			return parseInsideList
 		}
 	}
@@ -118,52 +145,157 @@ func parseInsideList(p *parser) stateFn {
 			p.backup()
 			return nil
 		// Cases with subs 
-		case token.Keyword(tok.Typ):
-			p.tree = p.tree.Append(&ast.Node{
+		case p.head && token.Keyword(tok.Typ):
+			p.head = false
+			top := &p.stack[len(p.stack)-1]
+			top.tree = p.stack[len(p.stack)-2].tree.Append(&ast.Node{
 				Typ: ast.ItemKey,
 				Key: tok.Typ,
 				Var: tok.Val,
+				Pos: p.position(tok),
 			})
-			return parseInsideList
+			// (quote ...) holds data
+			top.data = tok.Typ == token.ItemQuote || tok.Typ == token.ItemQuasiquote
+		case token.Quote(tok.Typ):
+			p.openPrefix(tok)
 		case token.Constant(tok.Typ) || tok.Typ == token.ItemVariable:
-			var node = new(ast.Node)
-			switch{
-			case tok.Typ == token.ItemVariable:
-				node.Typ = ast.ItemVar
-				node.Var = tok.Val
-			case tok.Typ == token.ItemString:
-				node.Typ = ast.ItemString
-				node.Str = tok.Val[1:len(tok.Val)-1]
-			case tok.Typ == token.ItemNumber:
-				node.Typ = ast.ItemNum
-				num, err := strconv.ParseFloat(tok.Val, 64)//ParseInt(tok.Val, 10, 32)
-				if err != nil {
-					log.Fatal(err)
-				}
-				node.Num = float64(num)//int32(num)
-			case tok.Typ == token.ItemBool:
-				node.Typ = ast.ItemNum
-				if tok.Val == "true" {
-					node.Num = 1
-				} else {
-					node.Num = 0
-				}
+			p.top().tree.Append(p.atom(tok))
+			if p.closePrefixes() {
+				return parseAll
 			}
-			p.tree.Append(node)
-		case tok.Typ == token.ItemEndList:
+		case tok.Typ == token.ItemEndList || tok.Typ == token.ItemEndVector || tok.Typ == token.ItemEndMap:
 			p.parenDepth--
-			tree, err := p.Root.Walk(p.parenDepth)
-			if err != nil {
-				log.Fatal(err)
+			if p.top().prefix {
+				return p.errorf("nothing to quote")
 			}
-			p.tree = tree
-			if p.parenDepth == 0 {
+			if t := p.top().tree; t != nil && !p.top().data && t.Val.Typ == ast.ItemKey {
+				if err := p.closeForm(t); err != nil {
+					return p.errorf("%s", err)
+				}
+			}
+			p.stack = p.stack[:len(p.stack)-1]
+			if p.closePrefixes() {
 				return parseAll
 			}
 		case tok.Typ == token.ItemBeginList:
 			p.parenDepth++
+			p.openList()
+		case tok.Typ == token.ItemBeginVector:
+			p.parenDepth++
+			p.openLiteral(ast.ItemVector)
+		case tok.Typ == token.ItemBeginMap:
+			p.parenDepth++
+			p.openLiteral(ast.ItemMap)
+		}
+	}
+}
+
+// top returns the innermost tree being parsed
+func (p *parser) top() *frame {
+	return &p.stack[len(p.stack)-1]
+}
+
+// openList starts a list, data lists are list values while code lists
+// are headed by the keyword that follows.
+func (p *parser) openList() {
+	if p.top().data {
+		p.stack = append(p.stack, frame{
+			tree: p.top().tree.Append(&ast.Node{Typ: ast.ItemList}),
+			data: true,
+		})
+	} else {
+		p.stack = append(p.stack, frame{})
+		p.head = true
+	}
+}
+
+// openLiteral starts a vector or map literal, its elements are data
+// or code like those of the list around it.
+func (p *parser) openLiteral(typ ast.ItemType) {
+	p.stack = append(p.stack, frame{
+		tree: p.top().tree.Append(&ast.Node{Typ: typ}),
+		data: p.top().data,
+	})
+}
+
+// closeForm finishes a form once all of it is parsed, returning an
+// error if it is malformed.
+func (p *parser) closeForm(t *ast.Tree) error {
+	switch t.Val.Key {
+	case token.ItemFunction:
+		ast.Sequence(t)
+		return ast.Annotate(t, p.isType)
+	case token.ItemAssign:
+		return ast.Annotate(t, p.isType)
+	case token.ItemDefrecord, token.ItemDeftype:
+		if len(t.Sub) > 0 && t.Sub[0].Val.Typ == ast.ItemVar {
+			p.types[t.Sub[0].Val.Var] = true
+		}
+	}
+	return nil
+}
+
+// isType reports whether name names a type annotations can use.
+func (p *parser) isType(name string) bool {
+	return ast.TypeNames[name] || p.types[name]
+}
+
+// openPrefix starts the form a quote prefix stands for, e.g. 'x is
+// (quote x).
+func (p *parser) openPrefix(tok token.Token) {
+	p.stack = append(p.stack, frame{
+		tree: p.top().tree.Append(&ast.Node{
+			Typ: ast.ItemKey,
+			Key: tok.Typ,
+			Var: tok.Val,
+			Pos: p.position(tok),
+		}),
+		data:   tok.Typ == token.ItemQuote || tok.Typ == token.ItemQuasiquote,
+		prefix: true,
+	})
+}
+
+// closePrefixes closes the quote prefixes that have their element,
+// reporting whether parsing is back at the top level.
+func (p *parser) closePrefixes() bool {
+	for p.top().prefix {
+		p.stack = p.stack[:len(p.stack)-1]
+	}
+	return len(p.stack) == 1
+}
+
+// atom returns the node for a constant or variable token.
+func (p *parser) atom(tok token.Token) *ast.Node {
+	var node = &ast.Node{Pos: p.position(tok)}
+	switch{
+	case tok.Typ == token.ItemVariable && p.top().data:
+		node.Typ = ast.ItemSym
+		node.Var = tok.Val
+	case tok.Typ == token.ItemVariable:
+		node.Typ = ast.ItemVar
+		node.Var = tok.Val
+	case tok.Typ == token.ItemString:
+		node.Typ = ast.ItemString
+		node.Str = tok.Val[1:len(tok.Val)-1]
+	case tok.Typ == token.ItemNumber:
+		node.Typ = ast.ItemNum
+		num, err := strconv.ParseFloat(tok.Val, 64)//ParseInt(tok.Val, 10, 32)
+		if err != nil {
+			log.Fatal(err)
+		}
+		node.Num = float64(num)//int32(num)
+	case tok.Typ == token.ItemAtom:
+		node.Typ = ast.ItemAtom
+		node.Atom = ast.Intern(tok.Val[1:])
+	case tok.Typ == token.ItemBool:
+		node.Typ = ast.ItemNum
+		if tok.Val == "true" {
+			node.Num = 1
+		} else {
+			node.Num = 0
 		}
 	}
+	return node
 }
 
 func isException(tok token.Token) bool {
@@ -0,0 +1,32 @@
+package parser
+
+import "testing"
+
+func TestPrefixes(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		// at the top level
+		{"'x", "quote{x}"},
+		{"`x", "quasiquote{x}"},
+		{",x", "unquote{(x)}"},
+		{",@x", "unquote-splicing{(x)}"},
+		{"''x", "quote{quote{x}}"},
+		{"'(a b)", "quote{list{a, b}}"},
+		// nested
+		{"(f 'x)", "unk{quote{x}}"},
+		{"(f `(a ,x ,@y))", "unk{quasiquote{list{a, unquote{(x)}, unquote-splicing{(y)}}}}"},
+		{"(f ,x)", "unk{unquote{(x)}}"},
+	}
+	for _, test := range tests {
+		tree := Parse(test.in, "test")
+		if len(tree.Sub) != 1 {
+			t.Errorf("%q parsed as %d forms, want 1", test.in, len(tree.Sub))
+		} else if got := tree.Sub[0].String(); got != test.want {
+			t.Errorf("%q parsed as %s, want %s", test.in, got, test.want)
+		}
+	}
+	if tree := Parse("'x 'y", "test"); len(tree.Sub) != 2 {
+		t.Errorf("'x 'y parsed as %s, want two forms", tree)
+	}
+}
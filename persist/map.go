@@ -0,0 +1,146 @@
+package persist
+
+// Map is a persistent map from strings, a balanced (AVL) binary tree
+// kept in key order. The zero value is empty.
+type Map struct {
+	root *tree
+	len  int
+}
+
+type tree struct {
+	key         string
+	val         interface{}
+	left, right *tree
+	height      int
+}
+
+// Len returns the number of keys in m.
+func (m *Map) Len() int {
+	return m.len
+}
+
+// Get returns the value of key in m and whether m has it.
+func (m *Map) Get(key string) (interface{}, bool) {
+	for t := m.root; t != nil; {
+		switch {
+		case key < t.key:
+			t = t.left
+		case key > t.key:
+			t = t.right
+		default:
+			return t.val, true
+		}
+	}
+	return nil, false
+}
+
+// Set returns m with key set to val.
+func (m *Map) Set(key string, val interface{}) *Map {
+	root, added := insert(m.root, key, val)
+	if added {
+		return &Map{root: root, len: m.len + 1}
+	}
+	return &Map{root: root, len: m.len}
+}
+
+// Delete returns m without key.
+func (m *Map) Delete(key string) *Map {
+	root, removed := remove(m.root, key)
+	if !removed {
+		return m
+	}
+	return &Map{root: root, len: m.len - 1}
+}
+
+// Each calls fn with each key and value of m in key order.
+func (m *Map) Each(fn func(key string, val interface{})) {
+	each(m.root, fn)
+}
+
+func each(t *tree, fn func(key string, val interface{})) {
+	if t == nil {
+		return
+	}
+	each(t.left, fn)
+	fn(t.key, t.val)
+	each(t.right, fn)
+}
+
+func height(t *tree) int {
+	if t == nil {
+		return 0
+	}
+	return t.height
+}
+
+// mk returns a new tree node.
+func mk(key string, val interface{}, left, right *tree) *tree {
+	h := height(left)
+	if hr := height(right); hr > h {
+		h = hr
+	}
+	return &tree{key: key, val: val, left: left, right: right, height: h + 1}
+}
+
+// balance returns mk(key, val, left, right) rotated so the heights of
+// its sides differ by at most one, which they may by two before.
+func balance(key string, val interface{}, left, right *tree) *tree {
+	hl, hr := height(left), height(right)
+	switch {
+	case hl > hr+1:
+		if height(left.left) >= height(left.right) {
+			return mk(left.key, left.val, left.left, mk(key, val, left.right, right))
+		}
+		lr := left.right
+		return mk(lr.key, lr.val, mk(left.key, left.val, left.left, lr.left), mk(key, val, lr.right, right))
+	case hr > hl+1:
+		if height(right.right) >= height(right.left) {
+			return mk(right.key, right.val, mk(key, val, left, right.left), right.right)
+		}
+		rl := right.left
+		return mk(rl.key, rl.val, mk(key, val, left, rl.left), mk(right.key, right.val, rl.right, right.right))
+	}
+	return mk(key, val, left, right)
+}
+
+// insert returns t with key set to val and whether key is new.
+func insert(t *tree, key string, val interface{}) (*tree, bool) {
+	if t == nil {
+		return mk(key, val, nil, nil), true
+	}
+	switch {
+	case key < t.key:
+		l, added := insert(t.left, key, val)
+		return balance(t.key, t.val, l, t.right), added
+	case key > t.key:
+		r, added := insert(t.right, key, val)
+		return balance(t.key, t.val, t.left, r), added
+	}
+	return mk(key, val, t.left, t.right), false
+}
+
+// remove returns t without key and whether it had it.
+func remove(t *tree, key string) (*tree, bool) {
+	if t == nil {
+		return nil, false
+	}
+	switch {
+	case key < t.key:
+		l, removed := remove(t.left, key)
+		return balance(t.key, t.val, l, t.right), removed
+	case key > t.key:
+		r, removed := remove(t.right, key)
+		return balance(t.key, t.val, t.left, r), removed
+	case t.left == nil:
+		return t.right, true
+	case t.right == nil:
+		return t.left, true
+	}
+	// replace t with the first node after it
+	next := t.right
+	for next.left != nil {
+		next = next.left
+	}
+	r, _ := remove(t.right, next.key)
+	return balance(next.key, next.val, t.left, r), true
+}
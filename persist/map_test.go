@@ -0,0 +1,121 @@
+package persist
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// check fails t unless m is ordered, balanced and holds want.
+func check(t *testing.T, m *Map, want map[string]int) {
+	t.Helper()
+	var verify func(tr *tree, lo, hi string) int
+	verify = func(tr *tree, lo, hi string) int {
+		if tr == nil {
+			return 0
+		}
+		if lo != "" && tr.key <= lo || hi != "" && tr.key >= hi {
+			t.Fatalf("key %q out of order between %q and %q", tr.key, lo, hi)
+		}
+		hl, hr := verify(tr.left, lo, tr.key), verify(tr.right, tr.key, hi)
+		if hl > hr+1 || hr > hl+1 {
+			t.Fatalf("unbalanced at %q: heights %d and %d", tr.key, hl, hr)
+		}
+		h := hl
+		if hr > h {
+			h = hr
+		}
+		if tr.height != h+1 {
+			t.Fatalf("height of %q is %d, want %d", tr.key, tr.height, h+1)
+		}
+		return h + 1
+	}
+	verify(m.root, "", "")
+	if m.Len() != len(want) {
+		t.Fatalf("Len() = %d, want %d", m.Len(), len(want))
+	}
+	for k, v := range want {
+		if got, ok := m.Get(k); !ok || got != v {
+			t.Fatalf("Get(%q) = %v, %v, want %d", k, got, ok, v)
+		}
+	}
+	n := 0
+	prev := ""
+	m.Each(func(k string, v interface{}) {
+		if n > 0 && k <= prev {
+			t.Fatalf("Each gave %q after %q", k, prev)
+		}
+		prev = k
+		n++
+	})
+	if n != len(want) {
+		t.Fatalf("Each gave %d keys, want %d", n, len(want))
+	}
+}
+
+func key(i int) string {
+	return fmt.Sprintf("k%05d", i)
+}
+
+func TestMapRebalance(t *testing.T) {
+	orders := map[string]func(n int) []int{
+		"ascending": func(n int) []int {
+			xs := make([]int, n)
+			for i := range xs {
+				xs[i] = i
+			}
+			return xs
+		},
+		"descending": func(n int) []int {
+			xs := make([]int, n)
+			for i := range xs {
+				xs[i] = n - 1 - i
+			}
+			return xs
+		},
+		"random": func(n int) []int {
+			return rand.New(rand.NewSource(1)).Perm(n)
+		},
+	}
+	for name, order := range orders {
+		m := new(Map)
+		want := make(map[string]int)
+		for _, i := range order(1000) {
+			m = m.Set(key(i), i)
+			want[key(i)] = i
+		}
+		check(t, m, want)
+		if h := height(m.root); h > 15 {
+			t.Errorf("%s: height %d for 1000 keys", name, h)
+		}
+		// delete every other key, in the same order
+		for _, i := range order(1000) {
+			if i%2 == 0 {
+				m = m.Delete(key(i))
+				delete(want, key(i))
+			}
+		}
+		check(t, m, want)
+	}
+}
+
+func TestMapPersistence(t *testing.T) {
+	m0 := new(Map)
+	m1 := m0.Set("a", 1).Set("b", 2).Set("c", 3)
+	m2 := m1.Set("b", 20)
+	m3 := m2.Delete("a")
+	check(t, m0, map[string]int{})
+	check(t, m1, map[string]int{"a": 1, "b": 2, "c": 3})
+	check(t, m2, map[string]int{"a": 1, "b": 20, "c": 3})
+	check(t, m3, map[string]int{"b": 20, "c": 3})
+}
+
+func TestMapDeleteMissing(t *testing.T) {
+	m := new(Map).Set("a", 1).Set("c", 3)
+	if d := m.Delete("b"); d != m {
+		t.Errorf("Delete of a missing key made a new map")
+	}
+	check(t, m.Delete("b"), map[string]int{"a": 1, "c": 3})
+	e := new(Map)
+	check(t, e.Delete("a"), map[string]int{})
+}
@@ -0,0 +1,94 @@
+// Package persist implements persistent vectors and maps: immutable
+// structures whose updates return new versions, sharing most of their
+// memory with the old ones.
+package persist
+
+const (
+	bits  = 5
+	width = 1 << bits
+	mask  = width - 1
+)
+
+// Vector is a persistent vector, a trie of width-way nodes indexed by
+// bits of the index from the root down. The zero value is empty.
+type Vector struct {
+	root  *node
+	shift uint // shift of the index at the root
+	len   int
+}
+
+// node is a trie node, holding *node children above the leaves and
+// elements in them.
+type node struct {
+	elems [width]interface{}
+}
+
+// NewVector returns a vector holding xs.
+func NewVector(xs ...interface{}) *Vector {
+	v := new(Vector)
+	for _, x := range xs {
+		v = v.Append(x)
+	}
+	return v
+}
+
+// Len returns the number of elements in v.
+func (v *Vector) Len() int {
+	return v.len
+}
+
+// Get returns the i'th element of v, which must be in range.
+func (v *Vector) Get(i int) interface{} {
+	if i < 0 || i >= v.len {
+		panic("persist: vector index out of range")
+	}
+	n := v.root
+	for s := v.shift; s > 0; s -= bits {
+		n = n.elems[(i>>s)&mask].(*node)
+	}
+	return n.elems[i&mask]
+}
+
+// Set returns v with its i'th element, which must be in range, set to x.
+func (v *Vector) Set(i int, x interface{}) *Vector {
+	if i < 0 || i >= v.len {
+		panic("persist: vector index out of range")
+	}
+	return &Vector{root: set(v.root, v.shift, i, x), shift: v.shift, len: v.len}
+}
+
+// Append returns v with x added to its end.
+func (v *Vector) Append(x interface{}) *Vector {
+	root, shift := v.root, v.shift
+	if v.len == width<<shift {
+		// full, add a level
+		root = &node{elems: [width]interface{}{root}}
+		shift += bits
+	}
+	return &Vector{root: set(root, shift, v.len, x), shift: shift, len: v.len + 1}
+}
+
+// Values returns the elements of v in order.
+func (v *Vector) Values() []interface{} {
+	xs := make([]interface{}, v.len)
+	for i := range xs {
+		xs[i] = v.Get(i)
+	}
+	return xs
+}
+
+// set returns a copy of n, which may be nil, and of the nodes below it
+// down to element i, with element i set to x.
+func set(n *node, shift uint, i int, x interface{}) *node {
+	c := new(node)
+	if n != nil {
+		*c = *n
+	}
+	if shift == 0 {
+		c.elems[i&mask] = x
+		return c
+	}
+	sub, _ := c.elems[(i>>shift)&mask].(*node)
+	c.elems[(i>>shift)&mask] = set(sub, shift-bits, i, x)
+	return c
+}
@@ -0,0 +1,61 @@
+package persist
+
+import "testing"
+
+func TestVectorGrow(t *testing.T) {
+	v := new(Vector)
+	var old []*Vector
+	for _, n := range []int{1, width, width + 1, width * width, width*width + 1, width*width*width + 1} {
+		for v.Len() < n {
+			v = v.Append(v.Len())
+		}
+		old = append(old, v)
+	}
+	for _, v := range old {
+		for i := 0; i < v.Len(); i++ {
+			if got := v.Get(i); got != i {
+				t.Fatalf("len %d: Get(%d) = %v, want %d", v.Len(), i, got, i)
+			}
+		}
+		if xs := v.Values(); len(xs) != v.Len() {
+			t.Fatalf("len %d: %d values", v.Len(), len(xs))
+		}
+	}
+}
+
+func TestVectorPersistence(t *testing.T) {
+	n := width*width + 5
+	v := NewVector()
+	for i := 0; i < n; i++ {
+		v = v.Append(i)
+	}
+	w := v.Set(0, "a").Set(width, "b").Set(n-1, "c")
+	u := v.Append("d")
+	for i := 0; i < n; i++ {
+		if got := v.Get(i); got != i {
+			t.Fatalf("old Get(%d) = %v after Set and Append, want %d", i, got, i)
+		}
+	}
+	for i, want := range map[int]interface{}{0: "a", 1: 1, width: "b", n - 1: "c"} {
+		if got := w.Get(i); got != want {
+			t.Errorf("Get(%d) = %v after Set, want %v", i, got, want)
+		}
+	}
+	if v.Len() != n || u.Len() != n+1 || u.Get(n) != "d" {
+		t.Errorf("Append: lengths %d and %d, last %v", v.Len(), u.Len(), u.Get(n))
+	}
+}
+
+func TestVectorRange(t *testing.T) {
+	v := NewVector(1, 2, 3)
+	for _, i := range []int{-1, 3} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Get(%d) of 3 elements didn't panic", i)
+				}
+			}()
+			v.Get(i)
+		}()
+	}
+}
@@ -33,6 +33,10 @@ const (
 	ItemEOF                       // end of file
 	ItemBeginList                 // starts a list
 	ItemEndList                   // ends a list
+	ItemBeginVector               // starts a vector, [
+	ItemEndVector                 // ends a vector, ]
+	ItemBeginMap                  // starts a map, {
+	ItemEndMap                    // ends a map, }
 	beginOperation
 	ItemAssign   // assgnment
 	ItemFunction // lambda keyword
@@ -52,6 +56,67 @@ const (
 	// Meta Operations (for ARM)
 	ItemDiv
 	ItemCmp // compare
+	ItemQuote           // quote, '
+	ItemQuasiquote      // quasiquote, `
+	ItemUnquote         // unquote, ,
+	ItemUnquoteSplicing // unquote-splicing, ,@
+	ItemCons            // cons onto a list
+	ItemCar             // first element of a list
+	ItemCdr             // list without its first element
+	ItemLength          // length of a list
+	ItemAppend          // concatenate lists
+	ItemNth             // n'th element of a list
+	ItemNull            // test for the empty list
+	ItemIsList          // test for a list
+	ItemMacro           // macro definition
+	ItemMacroExpand     // expanded form of a macro call
+	ItemGensym          // fresh symbol
+	ItemLet             // bind in a new scope
+	ItemLetStar         // bind in order in a new scope
+	ItemLetrec          // bind recursively in a new scope
+	ItemBegin           // evaluate in sequence
+	ItemMatch           // pattern match
+	ItemPartial         // fix the first arguments of a function
+	ItemCurry           // curry a function
+	ItemMap             // call a function on each element
+	ItemFilter          // keep the elements a function accepts
+	ItemReduce          // fold a list with a function
+	ItemApply           // call a function with a list of arguments
+	ItemCompose         // compose functions
+	ItemSortBy          // sort a list by a key function
+	ItemConcat          // join strings
+	ItemStrlen          // length of a string in characters
+	ItemSubstr          // part of a string
+	ItemSplit           // split a string around a separator
+	ItemJoin            // join a list of strings with a separator
+	ItemUpper           // upper case string
+	ItemLower           // lower case string
+	ItemContains        // test for a substring
+	ItemFormat          // format values like Go's fmt
+	ItemToString        // write a value as a string
+	ItemToNumber        // read a number from a string
+	ItemGet             // element of a vector or map
+	ItemAssoc           // vector or map with elements set
+	ItemDissoc          // map without keys
+	ItemKeys            // keys of a map
+	ItemVals            // values of a map
+	ItemDefrecord       // record type definition
+	ItemRecord          // record value
+	ItemIsRecord        // test for a record of a type
+	ItemField           // field of a record
+	ItemDeftype         // sum type definition
+	ItemVariant         // variant value of a sum type
+	ItemIsVariant       // test for a variant of a sum type
+	ItemThrow           // throw a value
+	ItemTry             // catch what its body throws
+	ItemCatch           // handler clause of a try
+	ItemFinally         // cleanup clause of a try
+	ItemNewError        // error value
+	ItemIsError         // test for an error value
+	ItemErrorMessage    // message of an error value
+	ItemAssert          // error unless a condition holds
+	ItemPre             // precondition clause of a lambda
+	ItemPost            // postcondition clause of a lambda
 	endOperation
 	beginCompare
 	ItemEq // Z set: test equality
@@ -67,11 +132,10 @@ const (
 	endCompare
 	beginConstant
 	ItemBool      // boolean true/false
-	ItemChar      // character 'c'
 	ItemComplex   // complex number
 	ItemNumber    // number
 	ItemString    // "string"
-	ItemRawString // `raw string`
+	ItemAtom      // keyword atom :name
 	endConstant
 	ItemVariable    // variable
 	ItemLineComment // comment '//' style
@@ -94,6 +158,77 @@ var key = map[string]ItemType{
 	"/": ItemDiv,
 	"cmp": ItemCmp,
 	"%": ItemMod,
+	// Quoting
+	"quote":            ItemQuote,
+	"quasiquote":       ItemQuasiquote,
+	"unquote":          ItemUnquote,
+	"unquote-splicing": ItemUnquoteSplicing,
+	// Lists
+	"cons":   ItemCons,
+	"car":    ItemCar,
+	"cdr":    ItemCdr,
+	"length": ItemLength,
+	"append": ItemAppend,
+	"nth":    ItemNth,
+	"null?":  ItemNull,
+	"list?":  ItemIsList,
+	// Macros
+	"macro":       ItemMacro,
+	"macroexpand": ItemMacroExpand,
+	"gensym":      ItemGensym,
+	// Binding
+	"let":    ItemLet,
+	"let*":   ItemLetStar,
+	"letrec": ItemLetrec,
+	// Sequencing
+	"begin": ItemBegin,
+	// Pattern matching
+	"match": ItemMatch,
+	// Functions
+	"partial": ItemPartial,
+	"curry":   ItemCurry,
+	"map":     ItemMap,
+	"filter":  ItemFilter,
+	"reduce":  ItemReduce,
+	"apply":   ItemApply,
+	"compose": ItemCompose,
+	"sort-by": ItemSortBy,
+	// Strings
+	"concat":         ItemConcat,
+	"strlen":         ItemStrlen,
+	"substr":         ItemSubstr,
+	"split":          ItemSplit,
+	"join":           ItemJoin,
+	"upper":          ItemUpper,
+	"lower":          ItemLower,
+	"contains":       ItemContains,
+	"format":         ItemFormat,
+	"->string":       ItemToString,
+	"string->number": ItemToNumber,
+	// Vectors and maps
+	"get":    ItemGet,
+	"assoc":  ItemAssoc,
+	"dissoc": ItemDissoc,
+	"keys":   ItemKeys,
+	"vals":   ItemVals,
+	// Records and types
+	"defrecord": ItemDefrecord,
+	"record":    ItemRecord,
+	"record?":   ItemIsRecord,
+	"field":     ItemField,
+	"deftype":   ItemDeftype,
+	"variant":   ItemVariant,
+	"variant?":  ItemIsVariant,
+	// Errors
+	"throw":         ItemThrow,
+	"try":           ItemTry,
+	"catch":         ItemCatch,
+	"finally":       ItemFinally,
+	"error":         ItemNewError,
+	"error?":        ItemIsError,
+	"error-message": ItemErrorMessage,
+	// Contracts
+	"assert": ItemAssert,
 	// Conditionals (conditional instruction prefixes)
 	"=": ItemEq,
 	"<": ItemLt,
@@ -102,6 +237,13 @@ var key = map[string]ItemType{
 	"<=": ItemLe,
 }
 
+// clause are the words that are keywords only heading a clause at the
+// start of a lambda body, and name functions anywhere else.
+var clause = map[string]ItemType{
+	"pre":  ItemPre,
+	"post": ItemPost,
+}
+
 const Eof = -1
 
 func IsKeyword(word string) bool {
@@ -112,6 +254,11 @@ func Constant(typ ItemType) bool {
 	return typ > beginConstant && typ < endConstant
 }
 
+// Quote reports whether typ is one of the quoting keywords.
+func Quote(typ ItemType) bool {
+	return typ >= ItemQuote && typ <= ItemUnquoteSplicing
+}
+
 func Keyword(typ ItemType) bool {
 	return typ > beginOperation && typ < endCompare
 }
@@ -121,11 +268,22 @@ func Lookup(word string) ItemType {
 	return key[word]
 }
 
+// Clause returns the clause keyword word is, if it is one.
+func Clause(word string) (ItemType, bool) {
+	t, ok := clause[word]
+	return t, ok
+}
+
 func StringLookup(t ItemType) string {
 	for i, j := range key {
 		if j == t {
 			return i
 		}
 	}
+	for i, j := range clause {
+		if j == t {
+			return i
+		}
+	}
 	return "unk"
 }
@@ -0,0 +1,107 @@
+package types
+
+import (
+	"github.com/cptaffe/lang/token"
+)
+
+var errorType = &Con{Name: "error"}
+
+// builtin returns the type of the builtin key called with n arguments,
+// or nil if it isn't known.
+func (c *checker) builtin(key token.ItemType, n int) *Func {
+	a, b := c.fresh(), c.fresh()
+	fn := func(result Type, params ...Type) *Func {
+		return &Func{Params: params, Min: len(params), Result: result}
+	}
+	switch key {
+	case token.ItemAdd, token.ItemAdc, token.ItemSub, token.ItemSbc, token.ItemMul,
+		token.ItemDiv, token.ItemMod, token.ItemAnd, token.ItemOrr, token.ItemEor, token.ItemBic:
+		return &Func{Rest: Num, Result: Num}
+	case token.ItemEq:
+		return fn(Num, a, a)
+	case token.ItemLt, token.ItemGt, token.ItemGe, token.ItemLe:
+		return fn(Num, Num, Num)
+	// lists
+	case token.ItemCons:
+		return fn(List(a), a, List(a))
+	case token.ItemCar:
+		return fn(a, List(a))
+	case token.ItemCdr:
+		return fn(List(a), List(a))
+	case token.ItemLength:
+		return fn(Num, List(a))
+	case token.ItemAppend:
+		return &Func{Rest: List(a), Result: List(a)}
+	case token.ItemNth:
+		return fn(a, Num, List(a))
+	case token.ItemNull:
+		return fn(Num, List(a))
+	case token.ItemIsList:
+		return fn(Num, a)
+	case token.ItemGensym:
+		return &Func{Params: []Type{Sym}, Result: Sym}
+	// functions
+	case token.ItemMap:
+		// (map f l...) calls f with an element of each list
+		f := &Func{Min: n - 1, Result: b}
+		params := []Type{f}
+		for i := 1; i < n; i++ {
+			el := c.fresh()
+			f.Params = append(f.Params, el)
+			params = append(params, List(el))
+		}
+		return fn(List(b), params...)
+	case token.ItemFilter:
+		return fn(List(a), fn(Num, a), List(a))
+	case token.ItemReduce:
+		if n == 2 {
+			return fn(a, fn(a, a, a), List(a))
+		}
+		return fn(b, fn(b, b, a), b, List(a))
+	case token.ItemSortBy:
+		return fn(List(a), fn(b, a), List(a))
+	// strings
+	case token.ItemConcat:
+		return &Func{Rest: Str, Result: Str}
+	case token.ItemStrlen:
+		return fn(Num, Str)
+	case token.ItemSubstr:
+		return &Func{Params: []Type{Str, Num, Num}, Min: 2, Result: Str}
+	case token.ItemSplit:
+		return fn(List(Str), Str, Str)
+	case token.ItemJoin:
+		return fn(Str, List(Str), Str)
+	case token.ItemUpper, token.ItemLower:
+		return fn(Str, Str)
+	case token.ItemContains:
+		return fn(Num, Str, Str)
+	case token.ItemFormat:
+		return &Func{Params: []Type{Str}, Min: 1, Rest: Any, Result: Str}
+	case token.ItemToString:
+		return fn(Str, a)
+	case token.ItemToNumber:
+		return fn(Num, Str)
+	// maps
+	case token.ItemKeys:
+		return fn(List(a), Map(a, b))
+	case token.ItemVals:
+		return fn(List(b), Map(a, b))
+	// records
+	case token.ItemIsRecord, token.ItemIsVariant:
+		return fn(Num, Atom, a)
+	case token.ItemField:
+		return fn(Any, Atom, Atom, a)
+	// errors
+	case token.ItemThrow:
+		return fn(b, a)
+	case token.ItemNewError:
+		return fn(errorType, Str)
+	case token.ItemIsError:
+		return fn(Num, a)
+	case token.ItemErrorMessage:
+		return fn(Str, a)
+	case token.ItemAssert:
+		return &Func{Params: []Type{Num, Str}, Min: 1, Result: Num}
+	}
+	return nil
+}
@@ -0,0 +1,591 @@
+package types
+
+import (
+	"fmt"
+
+	"github.com/cptaffe/lang/ast"
+	"github.com/cptaffe/lang/token"
+)
+
+// env binds names to their schemes, in a scope of the program.
+type env struct {
+	parent *env
+	names  map[string]*scheme
+}
+
+func newEnv(parent *env) *env {
+	return &env{parent: parent, names: make(map[string]*scheme)}
+}
+
+func (e *env) lookup(name string) *scheme {
+	for ; e != nil; e = e.parent {
+		if s, ok := e.names[name]; ok {
+			return s
+		}
+	}
+	return nil
+}
+
+func (e *env) bind(name string, s *scheme) {
+	e.names[name] = s
+}
+
+// checker infers the types of one program.
+type checker struct {
+	ids    int
+	level  int    // of the innermost binding being inferred
+	trail  []*Var // bound by unify, in order
+	pos    ast.Pos
+	errors []*Error
+}
+
+// Check infers the types of the top level forms of tree, giving the
+// types of the names they assign and the mistakes found in them.
+func Check(tree *ast.Tree) (map[string]Type, []*Error) {
+	c := new(checker)
+	e := newEnv(nil)
+	for _, t := range tree.Sub {
+		c.infer(e, t)
+	}
+	names := make(map[string]Type)
+	for name, s := range e.names {
+		names[name] = s.Type
+	}
+	return names, c.errors
+}
+
+// errorf reports a mistake in tree, or in the form it is in if tree has
+// no position.
+func (c *checker) errorf(tree *ast.Tree, format string, args ...interface{}) {
+	pos := c.pos
+	if tree.Val != nil && tree.Val.Pos.Line > 0 {
+		pos = tree.Val.Pos
+	}
+	c.errors = append(c.errors, &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+// infer returns the type of tree, evaluated in e.
+func (c *checker) infer(e *env, tree *ast.Tree) Type {
+	if tree.Val == nil {
+		return Any
+	}
+	if tree.Val.Pos.Line > 0 {
+		defer func(pos ast.Pos) { c.pos = pos }(c.pos)
+		c.pos = tree.Val.Pos
+	}
+	switch tree.Val.Typ {
+	case ast.ItemNum:
+		return Num
+	case ast.ItemString:
+		return Str
+	case ast.ItemAtom:
+		return Atom
+	case ast.ItemSym:
+		return Sym
+	case ast.ItemVar:
+		if s := e.lookup(tree.Val.Var); s != nil {
+			return c.instantiate(s)
+		}
+		return Any
+	case ast.ItemList:
+		return List(c.elems(e, tree.Sub))
+	case ast.ItemVector:
+		return Vector(c.elems(e, tree.Sub))
+	case ast.ItemMap:
+		k, v := Type(c.fresh()), Type(c.fresh())
+		for i := 0; i+1 < len(tree.Sub); i += 2 {
+			k = c.join(k, c.infer(e, tree.Sub[i]))
+			v = c.join(v, c.infer(e, tree.Sub[i+1]))
+		}
+		return Map(k, v)
+	case ast.ItemRecord:
+		if r := tree.Val.Rec; r.Sum != nil {
+			return &Con{Name: r.Sum.Name}
+		} else {
+			return &Con{Name: r.Type.Name}
+		}
+	case ast.ItemKey:
+		return c.key(e, tree)
+	}
+	return Any
+}
+
+// elems returns the type of the elements of a list or vector, which is
+// any if they don't agree.
+func (c *checker) elems(e *env, trees []*ast.Tree) Type {
+	t := Type(c.fresh())
+	for _, s := range trees {
+		t = c.join(t, c.infer(e, s))
+	}
+	return t
+}
+
+// key returns the type of a form headed by a keyword.
+func (c *checker) key(e *env, tree *ast.Tree) Type {
+	switch tree.Val.Key {
+	case token.ItemAssign:
+		return c.assign(e, tree)
+	case token.ItemFunction:
+		return c.lambda(e, tree)
+	case token.ItemLambda:
+		s := e.lookup(tree.Val.Var)
+		if s == nil {
+			return c.call(e, tree, tree.Val.Var, Any, tree.Sub)
+		}
+		return c.call(e, tree, tree.Val.Var, c.instantiate(s), tree.Sub)
+	case token.ItemSubAsOp:
+		if len(tree.Sub) == 0 {
+			return Any
+		}
+		return c.call(e, tree, tree.Sub[0].String(), c.infer(e, tree.Sub[0]), tree.Sub[1:])
+	case token.ItemCmp:
+		return c.cmp(e, tree)
+	case token.ItemBegin:
+		return c.begin(e, tree.Sub)
+	case token.ItemLet, token.ItemLetStar, token.ItemLetrec:
+		return c.let(e, tree)
+	case token.ItemMatch:
+		return c.match(e, tree)
+	case token.ItemTry:
+		return c.try(e, tree)
+	case token.ItemQuote:
+		if len(tree.Sub) != 1 {
+			return Any
+		}
+		return c.data(tree.Sub[0])
+	case token.ItemList:
+		return List(c.elems(e, tree.Sub))
+	case token.ItemMacro:
+		if len(tree.Sub) > 0 && tree.Sub[0].Val.Typ == ast.ItemVar {
+			e.bind(tree.Sub[0].Val.Var, mono(Any))
+		}
+		return Any
+	case token.ItemDefrecord, token.ItemDeftype:
+		c.deftype(e, tree)
+		return Any
+	case token.ItemRecord, token.ItemVariant:
+		// (record :name field...) and (variant :sum :name field...)
+		for _, s := range tree.Sub {
+			c.infer(e, s)
+		}
+		if len(tree.Sub) > 0 && tree.Sub[0].Val.Typ == ast.ItemAtom {
+			return &Con{Name: tree.Sub[0].Val.Atom.Name}
+		}
+		return Any
+	case token.ItemQuasiquote, token.ItemMacroExpand, token.ItemPre, token.ItemPost:
+		return Any
+	}
+	if fn := c.builtin(tree.Val.Key, len(tree.Sub)); fn != nil {
+		return c.call(e, tree, tree.Val.Var, fn, tree.Sub)
+	}
+	for _, s := range tree.Sub {
+		c.infer(e, s)
+	}
+	return Any
+}
+
+// assign binds the name (: name value) assigns in e. The type of a
+// lambda is generalized after it is inferred with its name bound, so it
+// can call itself.
+func (c *checker) assign(e *env, tree *ast.Tree) Type {
+	if len(tree.Sub) != 2 || tree.Sub[0].Val.Typ != ast.ItemVar {
+		return Any
+	}
+	name, value := tree.Sub[0].Val.Var, tree.Sub[1]
+	c.level++
+	self := c.fresh()
+	if isKey(value, token.ItemFunction) {
+		e.bind(name, mono(self))
+	}
+	t := c.infer(e, value)
+	if !c.unify(self, t) {
+		c.errorf(tree, "%s is %s where it is used, but %s is assigned", name, self, t)
+	}
+	if typ := tree.Sub[0].Val.Type; typ != "" {
+		if !c.unify(annotation(typ), t) {
+			c.errorf(tree, "%s is annotated %s, but %s is assigned", name, typ, t)
+		}
+		t = annotation(typ)
+	}
+	c.level--
+	e.bind(name, c.generalize(t))
+	return t
+}
+
+// lambda returns the type of the lambda value tree.
+func (c *checker) lambda(e *env, tree *ast.Tree) Type {
+	if len(tree.Sub) != 2 || !isKey(tree.Sub[0], token.ItemList) {
+		for _, s := range tree.Sub {
+			c.infer(e, s)
+		}
+		return Any
+	}
+	le := newEnv(e)
+	fn := &Func{Result: c.fresh()}
+	ps := tree.Sub[0].Sub
+	for i := 0; i < len(ps); i++ {
+		switch p := ps[i]; {
+		case isRestMarker(p):
+			if i+1 < len(ps) {
+				el := c.fresh()
+				fn.Rest = el
+				le.bind(ps[i+1].Val.Var, mono(List(el)))
+			}
+			i = len(ps)
+		case isKey(p, token.ItemLambda) && len(p.Sub) == 1:
+			// (name default), which can use the parameters before it
+			var t Type
+			if p.Val.Type != "" {
+				t = annotation(p.Val.Type)
+				c.expect(le, p.Sub[0], t, "default of %s", p.Val.Var)
+			} else {
+				t = c.infer(le, p.Sub[0])
+			}
+			le.bind(p.Val.Var, mono(t))
+			fn.Params = append(fn.Params, t)
+		default:
+			var t Type = c.fresh()
+			if p.Val.Type != "" {
+				t = annotation(p.Val.Type)
+			}
+			c.pattern(le, p, t)
+			fn.Params = append(fn.Params, t)
+			fn.Min = len(fn.Params)
+		}
+	}
+	le.bind("self", mono(fn))
+	body := []*ast.Tree{tree.Sub[1]}
+	if isKey(tree.Sub[1], token.ItemBegin) {
+		body = tree.Sub[1].Sub
+	}
+	// contracts: (pre cond...) and (post cond...), where result is the
+	// value of the body
+	re := newEnv(le)
+	re.bind("result", mono(fn.Result))
+	for len(body) > 1 && (isKey(body[0], token.ItemPre) || isKey(body[0], token.ItemPost)) {
+		ce := le
+		if isKey(body[0], token.ItemPost) {
+			ce = re
+		}
+		for _, cond := range body[0].Sub {
+			c.expect(ce, cond, Num, "condition %s", cond)
+		}
+		body = body[1:]
+	}
+	if t := c.begin(le, body); !c.unify(fn.Result, t) {
+		c.errorf(tree, "lambda gives %s, but its post conditions take %s", t, fn.Result)
+	}
+	return fn
+}
+
+// call returns the type of the call tree of the function name, of type
+// fn, with args.
+func (c *checker) call(e *env, tree *ast.Tree, name string, fn Type, args []*ast.Tree) Type {
+	ts := make([]Type, len(args))
+	for i, a := range args {
+		ts[i] = c.infer(e, a)
+	}
+	switch f := resolve(fn).(type) {
+	case *Var:
+		r := c.fresh()
+		if !c.unify(f, &Func{Params: ts, Min: len(ts), Result: r}) {
+			c.errorf(tree, "%s is %s, which can't be called with %d arguments", name, f, len(ts))
+			return Any
+		}
+		return r
+	case *Func:
+		if len(ts) < f.Min || f.Rest == nil && len(ts) > len(f.Params) {
+			c.errorf(tree, "%s takes %s, not %d", name, arity(f), len(ts))
+			return f.Result
+		}
+		for i, t := range ts {
+			p := f.Rest
+			if i < len(f.Params) {
+				p = f.Params[i]
+			}
+			if !c.unify(p, t) {
+				c.errorf(args[i], "argument %d of %s is %s, not %s", i+1, name, t, p)
+			}
+		}
+		return f.Result
+	case *Con:
+		if f != Fn {
+			c.errorf(tree, "%s is %s, not a function", name, f)
+		}
+	}
+	return Any
+}
+
+// arity describes how many arguments f takes.
+func arity(f *Func) string {
+	n, s := len(f.Params), "s"
+	if n == 1 {
+		s = ""
+	}
+	switch {
+	case f.Rest != nil:
+		if f.Min == 1 {
+			s = ""
+		}
+		return fmt.Sprintf("at least %d argument%s", f.Min, s)
+	case f.Min < n:
+		return fmt.Sprintf("%d to %d arguments", f.Min, n)
+	default:
+		return fmt.Sprintf("%d argument%s", n, s)
+	}
+}
+
+// expect infers the type of tree, reporting it as what unless it is t.
+func (c *checker) expect(e *env, tree *ast.Tree, t Type, format string, args ...interface{}) Type {
+	got := c.infer(e, tree)
+	if !c.unify(t, got) {
+		c.errorf(tree, "%s is %s, not %s", fmt.Sprintf(format, args...), got, t)
+	}
+	return got
+}
+
+// cmp returns the type of (cmp cond then else), whose condition is a
+// number and whose branches have the same type.
+func (c *checker) cmp(e *env, tree *ast.Tree) Type {
+	if len(tree.Sub) != 3 {
+		for _, s := range tree.Sub {
+			c.infer(e, s)
+		}
+		return Any
+	}
+	c.expect(e, tree.Sub[0], Num, "condition of cmp")
+	a, b := c.infer(e, tree.Sub[1]), c.infer(e, tree.Sub[2])
+	if !c.unify(a, b) {
+		c.errorf(tree, "branches of cmp are %s and %s", a, b)
+		return Any
+	}
+	return a
+}
+
+// begin returns the type of the last of trees, evaluated in order.
+func (c *checker) begin(e *env, trees []*ast.Tree) Type {
+	t := Any
+	for _, s := range trees {
+		t = c.infer(e, s)
+	}
+	return t
+}
+
+// let returns the type of (let (list (name value)...) body), binding
+// the names in a scope of their own.
+func (c *checker) let(e *env, tree *ast.Tree) Type {
+	if len(tree.Sub) != 2 || !isKey(tree.Sub[0], token.ItemList) {
+		return Any
+	}
+	le := newEnv(e)
+	var binds []*ast.Tree
+	for _, b := range tree.Sub[0].Sub {
+		if isKey(b, token.ItemLambda) && len(b.Sub) == 1 {
+			binds = append(binds, b)
+		}
+	}
+	ve := e
+	switch tree.Val.Key {
+	case token.ItemLetStar:
+		ve = le
+	case token.ItemLetrec:
+		ve = le
+		c.level++
+		for _, b := range binds {
+			le.bind(b.Val.Var, mono(c.fresh()))
+		}
+		c.level--
+	}
+	ts := make([]Type, len(binds))
+	for i, b := range binds {
+		c.level++
+		ts[i] = c.infer(ve, b.Sub[0])
+		if s := le.lookup(b.Val.Var); tree.Val.Key == token.ItemLetrec && !c.unify(s.Type, ts[i]) {
+			c.errorf(b, "%s is %s where it is used, but bound to %s", b.Val.Var, s.Type, ts[i])
+		}
+		c.level--
+		if tree.Val.Key == token.ItemLetStar {
+			le.bind(b.Val.Var, c.generalize(ts[i]))
+		}
+	}
+	for i, b := range binds {
+		le.bind(b.Val.Var, c.generalize(ts[i]))
+	}
+	return c.infer(le, tree.Sub[1])
+}
+
+// match returns the type of (match value pattern body...), whose bodies
+// have the same type.
+func (c *checker) match(e *env, tree *ast.Tree) Type {
+	if len(tree.Sub) == 0 {
+		return Any
+	}
+	v := c.infer(e, tree.Sub[0])
+	var t Type = c.fresh()
+	for i := 1; i+1 < len(tree.Sub); i += 2 {
+		me := newEnv(e)
+		c.pattern(me, tree.Sub[i], v)
+		b := c.infer(me, tree.Sub[i+1])
+		if !c.unify(t, b) {
+			c.errorf(tree.Sub[i+1], "case %s of match is %s, but those before it are %s", tree.Sub[i], b, t)
+			t = Any
+		}
+	}
+	return t
+}
+
+// pattern binds the names in a pattern matching values of type t in e.
+func (c *checker) pattern(e *env, p *ast.Tree, t Type) {
+	var want Type
+	switch {
+	case p.Val.Typ == ast.ItemVar:
+		if p.Val.Var != "_" {
+			e.bind(p.Val.Var, mono(t))
+		}
+		return
+	case p.Val.Typ == ast.ItemNum || p.Val.Typ == ast.ItemString || p.Val.Typ == ast.ItemAtom:
+		want = c.infer(e, p)
+	case isKey(p, token.ItemQuote) && len(p.Sub) == 1:
+		want = c.data(p.Sub[0])
+	case isKey(p, token.ItemList) || p.Val.Typ == ast.ItemVector:
+		el := c.fresh()
+		want = List(el)
+		if p.Val.Typ == ast.ItemVector {
+			want = Vector(el)
+		}
+		for i := 0; i < len(p.Sub); i++ {
+			if isRestMarker(p.Sub[i]) && i+1 < len(p.Sub) {
+				e.bind(p.Sub[i+1].Val.Var, mono(List(el)))
+				break
+			}
+			c.pattern(e, p.Sub[i], el)
+		}
+	case isKey(p, token.ItemLambda):
+		// (name p...) matches what the constructor name makes
+		var fn *Func
+		if s := e.lookup(p.Val.Var); s != nil {
+			fn, _ = resolve(c.instantiate(s)).(*Func)
+		}
+		if fn == nil || len(fn.Params) != len(p.Sub) {
+			for _, s := range p.Sub {
+				c.pattern(e, s, Any)
+			}
+			return
+		}
+		want = fn.Result
+		for i, s := range p.Sub {
+			c.pattern(e, s, fn.Params[i])
+		}
+	default:
+		return
+	}
+	if !c.unify(want, t) {
+		c.errorf(p, "pattern %s matches %s, not %s", p, want, t)
+	}
+}
+
+// try returns the type of (try body... (catch e handler...) (finally
+// cleanup...)), which is that of the body and of the handler.
+func (c *checker) try(e *env, tree *ast.Tree) Type {
+	var body []*ast.Tree
+	var handler *ast.Tree
+	for _, t := range tree.Sub {
+		switch {
+		case isKey(t, token.ItemCatch):
+			handler = t
+		case isKey(t, token.ItemFinally):
+			c.begin(e, t.Sub)
+		default:
+			body = append(body, t)
+		}
+	}
+	t := c.begin(e, body)
+	if handler == nil || len(handler.Sub) < 2 || handler.Sub[0].Val.Typ != ast.ItemVar {
+		return t
+	}
+	he := newEnv(e)
+	he.bind(handler.Sub[0].Val.Var, mono(Any))
+	if h := c.begin(he, handler.Sub[1:]); !c.unify(t, h) {
+		c.errorf(handler, "catch gives %s, but the body of the try gives %s", h, t)
+		return Any
+	}
+	return t
+}
+
+// data returns the type of quoted data.
+func (c *checker) data(tree *ast.Tree) Type {
+	switch {
+	case tree.Val.Typ == ast.ItemNum:
+		return Num
+	case tree.Val.Typ == ast.ItemString:
+		return Str
+	case tree.Val.Typ == ast.ItemSym:
+		return Sym
+	case tree.Val.Typ == ast.ItemAtom:
+		return Atom
+	case tree.Val.Typ == ast.ItemKey && tree.Val.Key != token.ItemList:
+		// code, which is a list headed by a symbol
+		return List(Any)
+	case tree.Val.Typ == ast.ItemVector || tree.Val.Typ == ast.ItemKey:
+		t := Type(c.fresh())
+		for _, s := range tree.Sub {
+			t = c.join(t, c.data(s))
+		}
+		if tree.Val.Typ == ast.ItemVector {
+			return Vector(t)
+		}
+		return List(t)
+	}
+	return Any
+}
+
+// deftype binds the constructors, predicates and accessors of a
+// defrecord or deftype form. Fields have type any.
+func (c *checker) deftype(e *env, tree *ast.Tree) {
+	if len(tree.Sub) < 2 || tree.Sub[0].Val.Typ != ast.ItemVar {
+		return
+	}
+	name := tree.Sub[0].Val.Var
+	t := &Con{Name: name}
+	c.predicate(e, name)
+	if tree.Val.Key == token.ItemDefrecord {
+		c.record(e, name, tree.Sub[1].Sub, t)
+		return
+	}
+	for _, v := range tree.Sub[1:] {
+		if isKey(v, token.ItemLambda) {
+			c.predicate(e, v.Val.Var)
+			c.record(e, v.Val.Var, v.Sub, t)
+		}
+	}
+}
+
+// record binds the constructor and accessors of the record type name,
+// whose values are of type t.
+func (c *checker) record(e *env, name string, fields []*ast.Tree, t Type) {
+	fn := &Func{Min: len(fields), Result: t}
+	for _, f := range fields {
+		fn.Params = append(fn.Params, Any)
+		e.bind(name+"-"+f.Val.Var, mono(&Func{Params: []Type{t}, Min: 1, Result: Any}))
+	}
+	e.bind(name, mono(fn))
+}
+
+// predicate binds name?, which tests a value of any type.
+func (c *checker) predicate(e *env, name string) {
+	c.level++
+	a := c.fresh()
+	c.level--
+	e.bind(name+"?", c.generalize(&Func{Params: []Type{a}, Min: 1, Result: Num}))
+}
+
+// isKey reports whether tree is headed by the keyword key.
+func isKey(tree *ast.Tree, key token.ItemType) bool {
+	return tree.Val != nil && tree.Val.Typ == ast.ItemKey && tree.Val.Key == key
+}
+
+// isRestMarker reports whether tree is the &rest or . before a rest
+// parameter.
+func isRestMarker(tree *ast.Tree) bool {
+	return tree.Val.Typ == ast.ItemVar && (tree.Val.Var == "&rest" || tree.Val.Var == ".")
+}
@@ -0,0 +1,163 @@
+// Package types infers the types of programs, Hindley–Milner style, to
+// find mistakes like (+ "a" 1) before they are evaluated.
+//
+// Numbers, strings, atoms, symbols, lists, vectors, maps, functions and
+// the types defrecord and deftype define have types of their own, and
+// the types of lambdas assigned with : or bound by a let are
+// generalized, so (: id (lambda (list x) x)) can be called with any
+// argument. As scoping is dynamic, names that aren't bound where they are
+// used, and the values of builtins whose type depends on their arguments
+// in ways these types can't say, have type any, which agrees with every
+// type.
+package types
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cptaffe/lang/ast"
+)
+
+// Type is the type of a value.
+type Type interface {
+	String() string
+}
+
+// Con is a named type applied to the types of its elements, like num or
+// (list num).
+type Con struct {
+	Name string
+	Args []Type
+}
+
+func (c *Con) String() string {
+	if len(c.Args) == 0 {
+		return c.Name
+	}
+	s := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		s[i] = a.String()
+	}
+	return fmt.Sprintf("(%s %s)", c.Name, strings.Join(s, " "))
+}
+
+// Func is the type of a function.
+type Func struct {
+	Params []Type
+	Min    int  // how many of Params are required
+	Rest   Type // of each argument after Params, nil if there can't be any
+	Result Type
+}
+
+func (f *Func) String() string {
+	s := []string{"fn"}
+	for i, p := range f.Params {
+		if i < f.Min {
+			s = append(s, p.String())
+		} else {
+			s = append(s, "["+p.String()+"]")
+		}
+	}
+	if f.Rest != nil {
+		s = append(s, f.Rest.String()+"...")
+	}
+	return fmt.Sprintf("(%s -> %s)", strings.Join(s, " "), f.Result)
+}
+
+// Var is a type variable, standing for a type inference hasn't found.
+type Var struct {
+	ID    int
+	Ref   Type // the type it stands for, once found
+	level int  // of the binding it was made for
+}
+
+func (v *Var) String() string {
+	if v.Ref != nil {
+		return v.Ref.String()
+	}
+	return fmt.Sprintf("t%d", v.ID)
+}
+
+type anyType struct{}
+
+func (anyType) String() string { return "any" }
+
+// Any is the type of values whose type isn't known statically.
+var Any Type = anyType{}
+
+// the types of constants
+var (
+	Num  Type = &Con{Name: "num"}
+	Str  Type = &Con{Name: "str"}
+	Atom Type = &Con{Name: "atom"}
+	Sym  Type = &Con{Name: "sym"}
+)
+
+// Fn is the type of functions of any arity, which agrees with every
+// function type.
+var Fn Type = &Con{Name: "fn"}
+
+// List returns the type of lists of elem.
+func List(elem Type) Type {
+	return &Con{Name: "list", Args: []Type{elem}}
+}
+
+// Vector returns the type of vectors of elem.
+func Vector(elem Type) Type {
+	return &Con{Name: "vector", Args: []Type{elem}}
+}
+
+// Map returns the type of maps from key to val.
+func Map(key, val Type) Type {
+	return &Con{Name: "map", Args: []Type{key, val}}
+}
+
+// annotation returns the type named by an annotation, one of
+// ast.TypeNames or a type defrecord or deftype defined.
+func annotation(name string) Type {
+	switch name {
+	case "num", "int":
+		return Num
+	case "str":
+		return Str
+	case "atom":
+		return Atom
+	case "sym":
+		return Sym
+	case "list":
+		return List(Any)
+	case "vector":
+		return Vector(Any)
+	case "map":
+		return Map(Any, Any)
+	case "fn":
+		return Fn
+	case "any":
+		return Any
+	}
+	return &Con{Name: name}
+}
+
+// resolve returns the type t stands for, following type variables.
+func resolve(t Type) Type {
+	for {
+		v, ok := t.(*Var)
+		if !ok || v.Ref == nil {
+			return t
+		}
+		t = v.Ref
+	}
+}
+
+// Error is a mistake found in a program.
+type Error struct {
+	Pos ast.Pos // of the form it is in
+	Msg string
+}
+
+func (e *Error) Error() string {
+	if e.Pos.Line == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
@@ -0,0 +1,59 @@
+package types
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cptaffe/lang/parser"
+)
+
+// check infers the types of the program s, failing unless its errors
+// are those containing errs, in order.
+func check(t *testing.T, s string, errs ...string) map[string]Type {
+	types, got := Check(parser.Parse(s, "test"))
+	if len(got) != len(errs) {
+		t.Errorf("%s: errors %v, want %d", s, got, len(errs))
+		return types
+	}
+	for i, err := range got {
+		if !strings.Contains(err.Error(), errs[i]) {
+			t.Errorf("%s: error %q, want it to contain %q", s, err, errs[i])
+		}
+	}
+	return types
+}
+
+func TestLetPolymorphism(t *testing.T) {
+	types := check(t, `(: id (lambda (list x) x)) (: a (id 1)) (: b (id "s")) (: c (id (list 1)))`)
+	for name, want := range map[string]string{"a": "num", "b": "str", "c": "(list num)"} {
+		if got := types[name].String(); got != want {
+			t.Errorf("%s is %s, want %s", name, got, want)
+		}
+	}
+	if f, ok := resolve(types["id"]).(*Func); !ok || len(f.Params) != 1 || resolve(f.Params[0]) != resolve(f.Result) {
+		t.Errorf("id is %s, want a function from a type to itself", types["id"])
+	}
+	// a let binding is generalized too
+	check(t, `(: n (let (list (id (lambda (list x) x))) (begin (id "s") (id 1))))`)
+	// a parameter is not
+	check(t, `(: f (lambda (list g) (begin (g 1) (g "s"))))`, "argument 1 of g is str, not num")
+}
+
+func TestOccursCheck(t *testing.T) {
+	check(t, `(: f (lambda (list x) (cons x x)))`, "argument 2 of cons is t3, not (list t3)")
+	check(t, `(: f (lambda (list x) (x x)))`, "can't be called with 1 arguments")
+	check(t, `(: f (lambda (list x) (: y (list x)) (= x y)))`, "argument 2 of = is (list t3), not t3")
+}
+
+func TestArity(t *testing.T) {
+	const f = `(: f (lambda (list x y) (+ x y))) `
+	check(t, f+`(f 1)`, "f takes 2 arguments, not 1")
+	check(t, f+`(f 1 2 3)`, "f takes 2 arguments, not 3")
+	check(t, f+`(f 1 2)`)
+	check(t, `(: f (lambda (list x (y 1)) (+ x y))) (f)`, "f takes 1 to 2 arguments, not 0")
+	check(t, `(: f (lambda (list x &rest r) x)) (f)`, "f takes at least 1 argument, not 0")
+	// functions of different arities don't unify
+	check(t, `(: twice (lambda (list g) (g 1 2))) (twice (lambda (list x) x))`,
+		"argument 1 of twice is (fn t7 -> t7), not (fn num num -> t5)")
+	check(t, `(: twice (lambda (list g) (g 1 2))) (twice (lambda (list x y) x))`)
+}
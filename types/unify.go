@@ -0,0 +1,202 @@
+package types
+
+// scheme is the type of a name, which is instantiated with fresh type
+// variables for Vars wherever the name is used.
+type scheme struct {
+	Vars []*Var
+	Type Type
+}
+
+// mono returns the scheme of a name whose type isn't generalized.
+func mono(t Type) *scheme {
+	return &scheme{Type: t}
+}
+
+// fresh returns a new type variable.
+func (c *checker) fresh() *Var {
+	c.ids++
+	return &Var{ID: c.ids, level: c.level}
+}
+
+// unify makes a and b the same type, reporting whether they can be. If
+// they can't, the type variables are left as they were.
+func (c *checker) unify(a, b Type) bool {
+	mark := len(c.trail)
+	if c.unifyAll(a, b) {
+		return true
+	}
+	for _, v := range c.trail[mark:] {
+		v.Ref = nil
+	}
+	c.trail = c.trail[:mark]
+	return false
+}
+
+func (c *checker) unifyAll(a, b Type) bool {
+	a, b = resolve(a), resolve(b)
+	if a == b {
+		return true
+	}
+	if v, ok := a.(*Var); ok {
+		return c.bind(v, b)
+	}
+	if v, ok := b.(*Var); ok {
+		return c.bind(v, a)
+	}
+	if a == Any || b == Any {
+		return true
+	}
+	if _, ok := b.(*Func); ok && a == Fn {
+		return true
+	}
+	if _, ok := a.(*Func); ok && b == Fn {
+		return true
+	}
+	switch a := a.(type) {
+	case *Con:
+		b, ok := b.(*Con)
+		if !ok || a.Name != b.Name || len(a.Args) != len(b.Args) {
+			return false
+		}
+		for i := range a.Args {
+			if !c.unifyAll(a.Args[i], b.Args[i]) {
+				return false
+			}
+		}
+		return true
+	case *Func:
+		b, ok := b.(*Func)
+		if !ok || len(a.Params) != len(b.Params) || a.Min != b.Min || (a.Rest == nil) != (b.Rest == nil) {
+			return false
+		}
+		for i := range a.Params {
+			if !c.unifyAll(a.Params[i], b.Params[i]) {
+				return false
+			}
+		}
+		if a.Rest != nil && !c.unifyAll(a.Rest, b.Rest) {
+			return false
+		}
+		return c.unifyAll(a.Result, b.Result)
+	}
+	return false
+}
+
+// bind makes v stand for t, unless t contains v.
+func (c *checker) bind(v *Var, t Type) bool {
+	if c.occurs(v, t) {
+		return false
+	}
+	v.Ref = t
+	c.trail = append(c.trail, v)
+	return true
+}
+
+// occurs reports whether v is in t, lowering the level of the variables
+// in t to that of v, as they are now bound where v is.
+func (c *checker) occurs(v *Var, t Type) bool {
+	switch t := resolve(t).(type) {
+	case *Var:
+		if t.level > v.level {
+			t.level = v.level
+		}
+		return t == v
+	case *Con:
+		for _, a := range t.Args {
+			if c.occurs(v, a) {
+				return true
+			}
+		}
+	case *Func:
+		for _, p := range t.Params {
+			if c.occurs(v, p) {
+				return true
+			}
+		}
+		return t.Rest != nil && c.occurs(v, t.Rest) || c.occurs(v, t.Result)
+	}
+	return false
+}
+
+// join gives the type of values of type a or b: a, if they unify, and
+// any otherwise.
+func (c *checker) join(a, b Type) Type {
+	if c.unify(a, b) {
+		return a
+	}
+	return Any
+}
+
+// generalize returns the scheme of a name bound to a value of type t,
+// which is generic in the type variables made while inferring it.
+func (c *checker) generalize(t Type) *scheme {
+	s := &scheme{Type: t}
+	seen := make(map[*Var]bool)
+	var visit func(t Type)
+	visit = func(t Type) {
+		switch t := resolve(t).(type) {
+		case *Var:
+			if t.level > c.level && !seen[t] {
+				seen[t] = true
+				s.Vars = append(s.Vars, t)
+			}
+		case *Con:
+			for _, a := range t.Args {
+				visit(a)
+			}
+		case *Func:
+			for _, p := range t.Params {
+				visit(p)
+			}
+			if t.Rest != nil {
+				visit(t.Rest)
+			}
+			visit(t.Result)
+		}
+	}
+	visit(t)
+	return s
+}
+
+// instantiate returns the type of a use of a name with scheme s.
+func (c *checker) instantiate(s *scheme) Type {
+	if len(s.Vars) == 0 {
+		return s.Type
+	}
+	sub := make(map[*Var]Type)
+	for _, v := range s.Vars {
+		sub[v] = c.fresh()
+	}
+	return substitute(s.Type, sub)
+}
+
+// substitute returns t with the variables in sub replaced.
+func substitute(t Type, sub map[*Var]Type) Type {
+	switch t := resolve(t).(type) {
+	case *Var:
+		if s, ok := sub[t]; ok {
+			return s
+		}
+		return t
+	case *Con:
+		if len(t.Args) == 0 {
+			return t
+		}
+		args := make([]Type, len(t.Args))
+		for i, a := range t.Args {
+			args[i] = substitute(a, sub)
+		}
+		return &Con{Name: t.Name, Args: args}
+	case *Func:
+		f := &Func{Min: t.Min, Result: substitute(t.Result, sub)}
+		for _, p := range t.Params {
+			f.Params = append(f.Params, substitute(p, sub))
+		}
+		if t.Rest != nil {
+			f.Rest = substitute(t.Rest, sub)
+		}
+		return f
+	default:
+		return t
+	}
+}
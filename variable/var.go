@@ -28,6 +28,11 @@ func (scope Scope) GetName(s string) *Var {
 	return nil
 }
 
+// Add adds a variable to the scope
+func (scope *Scope) Add(v *Var) {
+	scope.Scope = append(scope.Scope, v)
+}
+
 func (v *Var) String() string {
 	return v.Tree.String()
 }
\ No newline at end of file